@@ -0,0 +1,9 @@
+package constantscore
+
+const (
+	// CursorSecretEnv environment variable holding the secret used to sign pagination cursors.
+	CursorSecretEnv = "CURSOR_SECRET"
+
+	// TableNameEnvSuffix suffix of the environment variables holding table names, such as MERCHANTS_TABLE.
+	TableNameEnvSuffix = "_TABLE"
+)
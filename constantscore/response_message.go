@@ -13,6 +13,9 @@ const (
 	// ErrorCreatingItem error creating item.
 	ErrorCreatingItem = "Error creating item"
 
+	// ItemAlreadyExists item already exists.
+	ItemAlreadyExists = "Item already exists"
+
 	// ErrorDeletingItem error deleting item.
 	ErrorDeletingItem = "Error deleting element"
 
@@ -31,6 +34,9 @@ const (
 	// ItemDeletedSuccessfully message to deleted successful.
 	ItemDeletedSuccessfully = "Item deleted successfully"
 
+	// InvalidCursor invalid pagination cursor.
+	InvalidCursor = "Invalid pagination cursor"
+
 	// ItemSuccessfullyUpdated message for successful deletion.
 	ItemSuccessfullyUpdated = "Item successfully updated"
 )
@@ -0,0 +1,337 @@
+package dynamodbcore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/streams"
+	"github.com/diegocabrera89/ms-payment-core/tracking"
+	"strings"
+	"time"
+)
+
+const (
+	// HistoryKeyAttribute is the partition key of the history table, see HistoryItemKey.
+	HistoryKeyAttribute = "itemKey"
+	// HistorySortKeyAttribute is the sort key of the history table, ordering the changes of an item in time.
+	HistorySortKeyAttribute = "changeID"
+	// defaultAuditIdentity is recorded when the request carries no identity.
+	defaultAuditIdentity = "system"
+)
+
+// Operations recorded in the history table.
+const (
+	HistoryOperationCreate = "CREATE"
+	HistoryOperationPut    = "PUT"
+	HistoryOperationUpdate = "UPDATE"
+	HistoryOperationDelete = "DELETE"
+)
+
+// AuditPolicy defines the audit attributes stamped on every put, update and delete, and the optional
+// table receiving an immutable history record of each change. Empty attribute names take the defaults
+// createdAt, updatedAt, createdBy and updatedBy. Upserts of items lacking the creation attributes read the
+// stored item to keep its own, or stamp them when there is none, and require WithKeySchema; they fail with
+// ErrVersionConflict when the item is created or replaced in between. Transactions may only write the table
+// with TransactionBuilder.PutItem, and batch writes are rejected, since neither could be audited otherwise.
+type AuditPolicy struct {
+	CreatedAtAttribute string
+	UpdatedAtAttribute string
+	CreatedByAttribute string
+	UpdatedByAttribute string
+	// Identity resolves who makes the request, AuditIdentity by default.
+	Identity func(request events.APIGatewayProxyRequest) string
+	// DefaultIdentity is recorded when Identity finds none, as in stream consumers. Defaults to "system".
+	DefaultIdentity string
+	// HistoryTable, when set, receives a HistoryRecord for every change, written in the same transaction as
+	// the change. It has the string partition key HistoryKeyAttribute and the string sort key
+	// HistorySortKeyAttribute, and requires WithKeySchema.
+	HistoryTable string
+}
+
+// HistoryRecord is a change stored in the history table. ChangedAttributes lists the attributes whose value
+// the change modified, by name for puts and deletes and by name or nested path for updates, and Before and
+// After hold their values before and after the change, leaving out those that did not exist: a creation has
+// no Before and the deletion of an item records the deleted item in Before.
+// The previous values are read right before the change, which is made on the condition that the item did not
+// change in between and is tried again otherwise.
+type HistoryRecord struct {
+	ItemKey           string                 `dynamodbav:"itemKey"`
+	ChangeID          string                 `dynamodbav:"changeID"`
+	TableName         string                 `dynamodbav:"tableName"`
+	Operation         string                 `dynamodbav:"operation"`
+	ChangedAt         string                 `dynamodbav:"changedAt"`
+	ChangedBy         string                 `dynamodbav:"changedBy"`
+	RequestID         string                 `dynamodbav:"requestID"`
+	ChangedAttributes []string               `dynamodbav:"changedAttributes"`
+	Before            map[string]interface{} `dynamodbav:"before"`
+	After             map[string]interface{} `dynamodbav:"after"`
+}
+
+// WithAudit stamps the audit attributes on every write and, when policy.HistoryTable is set, records its history.
+func WithAudit(policy AuditPolicy) Option {
+	return func(d *DynamoDBRepository) {
+		if len(policy.CreatedAtAttribute) == 0 {
+			policy.CreatedAtAttribute = "createdAt"
+		}
+		if len(policy.UpdatedAtAttribute) == 0 {
+			policy.UpdatedAtAttribute = "updatedAt"
+		}
+		if len(policy.CreatedByAttribute) == 0 {
+			policy.CreatedByAttribute = "createdBy"
+		}
+		if len(policy.UpdatedByAttribute) == 0 {
+			policy.UpdatedByAttribute = "updatedBy"
+		}
+		if policy.Identity == nil {
+			policy.Identity = AuditIdentity
+		}
+		if len(policy.DefaultIdentity) == 0 {
+			policy.DefaultIdentity = defaultAuditIdentity
+		}
+		d.audit = &policy
+	}
+}
+
+// AuditIdentity returns the caller of an API Gateway request: the principal of a Lambda authorizer,
+// the subject of Cognito user pool claims or the ARN of an IAM caller, empty when there is none.
+func AuditIdentity(request events.APIGatewayProxyRequest) string {
+	authorizer := request.RequestContext.Authorizer
+	if principalID, ok := authorizer["principalId"].(string); ok && len(principalID) != 0 {
+		return principalID
+	}
+	if claims, ok := authorizer["claims"].(map[string]interface{}); ok {
+		if subject, found := claims["sub"].(string); found && len(subject) != 0 {
+			return subject
+		}
+	}
+	return request.RequestContext.Identity.UserArn
+}
+
+// HistoryItemKey returns the history table partition key holding the changes of the item stored at key in table.
+func HistoryItemKey(table string, key Key) string {
+	parts := []string{table}
+	for _, attribute := range key.attributes() {
+		switch value := attribute.Value.(type) {
+		case *types.AttributeValueMemberS:
+			parts = append(parts, value.Value)
+		case *types.AttributeValueMemberN:
+			parts = append(parts, value.Value)
+		case *types.AttributeValueMemberB:
+			parts = append(parts, base64.StdEncoding.EncodeToString(value.Value))
+		}
+	}
+	return strings.Join(parts, "#")
+}
+
+// auditIdentity returns who makes the request.
+func (d DynamoDBRepository) auditIdentity(request events.APIGatewayProxyRequest) string {
+	if identity := d.audit.Identity(request); len(identity) != 0 {
+		return identity
+	}
+	return d.audit.DefaultIdentity
+}
+
+// auditAttributes returns the attributes the audit stamps, which callers cannot update themselves.
+func (d DynamoDBRepository) auditAttributes() []string {
+	if d.audit == nil {
+		return nil
+	}
+	return []string{d.audit.CreatedAtAttribute, d.audit.UpdatedAtAttribute, d.audit.CreatedByAttribute, d.audit.UpdatedByAttribute}
+}
+
+// stampPut sets the audit attributes of an item about to be put. Creations always set the creation attributes,
+// and upserts keep those carried by item or leave them to stampCreation.
+func (d DynamoDBRepository) stampPut(item map[string]types.AttributeValue, request events.APIGatewayProxyRequest, create bool) {
+	now := StringValue(time.Now().UTC().Format(time.RFC3339))
+	identity := StringValue(d.auditIdentity(request))
+	if create {
+		item[d.audit.CreatedAtAttribute] = now
+		item[d.audit.CreatedByAttribute] = identity
+	}
+	item[d.audit.UpdatedAtAttribute] = now
+	item[d.audit.UpdatedByAttribute] = identity
+}
+
+// lacksCreation reports whether an item about to be upserted lacks any of the creation attributes.
+func (d DynamoDBRepository) lacksCreation(item map[string]types.AttributeValue) bool {
+	_, hasCreatedAt := item[d.audit.CreatedAtAttribute]
+	_, hasCreatedBy := item[d.audit.CreatedByAttribute]
+	return !hasCreatedAt || !hasCreatedBy
+}
+
+// stampCreation sets the creation attributes of an item stamped by stampPut and about to be upserted without
+// them: the stored item keeps its own, and an item not stored yet is stamped as created by the upsert.
+// The returned conditions make the put fail when the stored item is created or replaced in between.
+func (d DynamoDBRepository) stampCreation(ctx context.Context, item map[string]types.AttributeValue) ([]expression.ConditionBuilder, error) {
+	if !d.lacksCreation(item) {
+		return nil, nil
+	}
+	stored, err := d.itemBefore(ctx, d.keyOfItem(item))
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		item[d.audit.CreatedAtAttribute] = item[d.audit.UpdatedAtAttribute]
+		item[d.audit.CreatedByAttribute] = item[d.audit.UpdatedByAttribute]
+		return []expression.ConditionBuilder{expression.AttributeNotExists(expression.NameNoDotSplit(d.partitionKeyName))}, nil
+	}
+	conditions := []expression.ConditionBuilder{expression.AttributeExists(expression.NameNoDotSplit(d.partitionKeyName))}
+	for _, name := range []string{d.audit.CreatedAtAttribute, d.audit.CreatedByAttribute} {
+		value, found := stored[name]
+		if !found {
+			delete(item, name)
+			conditions = append(conditions, expression.AttributeNotExists(expression.NameNoDotSplit(name)))
+			continue
+		}
+		item[name] = value
+		conditions = append(conditions, expression.NameNoDotSplit(name).Equal(expression.Value(value)))
+	}
+	return conditions, nil
+}
+
+// stampUpdate adds the update attributes of the audit to an update expression, and to the updates of change.
+func (d DynamoDBRepository) stampUpdate(update expression.UpdateBuilder, request events.APIGatewayProxyRequest, change *historyChange) expression.UpdateBuilder {
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	updatedBy := d.auditIdentity(request)
+	change.updates = append(change.updates,
+		historyUpdate{path: d.audit.UpdatedAtAttribute, value: StringValue(updatedAt)},
+		historyUpdate{path: d.audit.UpdatedByAttribute, value: StringValue(updatedBy)},
+	)
+	return update.
+		Set(expression.Name(d.audit.UpdatedAtAttribute), expression.Value(updatedAt)).
+		Set(expression.Name(d.audit.UpdatedByAttribute), expression.Value(updatedBy))
+}
+
+// recordsHistory reports whether changes are recorded in a history table.
+func (d DynamoDBRepository) recordsHistory() bool {
+	return d.audit != nil && len(d.audit.HistoryTable) != 0
+}
+
+// historyRecord builds the history record of change, which turns before into after, to be put in the history
+// table in the same transaction.
+func (d DynamoDBRepository) historyRecord(ctx context.Context, request events.APIGatewayProxyRequest, change historyChange, before map[string]types.AttributeValue, after map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	changed := change.changedAttributes(before, after)
+	changedAttributes := make([]types.AttributeValue, 0, len(changed))
+	beforeValues := make(map[string]types.AttributeValue)
+	afterValues := make(map[string]types.AttributeValue)
+	for _, name := range changed {
+		changedAttributes = append(changedAttributes, StringValue(name))
+		if value, found := change.valueAt(before, name); found {
+			beforeValues[name] = value
+		}
+		if value, found := change.valueAt(after, name); found {
+			afterValues[name] = value
+		}
+	}
+
+	changedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("generate change ID: %w", err)
+	}
+	return map[string]types.AttributeValue{
+		HistoryKeyAttribute:     StringValue(HistoryItemKey(d.table, change.key)),
+		HistorySortKeyAttribute: StringValue(changedAt + "#" + hex.EncodeToString(suffix)),
+		"tableName":             StringValue(d.table),
+		"operation":             StringValue(change.operation),
+		"changedAt":             StringValue(changedAt),
+		"changedBy":             StringValue(d.auditIdentity(request)),
+		"requestID":             StringValue(tracking.GetRequestId(ctx, request)),
+		"changedAttributes":     &types.AttributeValueMemberL{Value: changedAttributes},
+		"before":                &types.AttributeValueMemberM{Value: beforeValues},
+		"after":                 &types.AttributeValueMemberM{Value: afterValues},
+	}, nil
+}
+
+// historyCondition keeps a history record from replacing another one with the same change ID.
+func historyCondition() expression.ConditionBuilder {
+	return expression.AttributeNotExists(expression.Name(HistorySortKeyAttribute))
+}
+
+// writeWithHistory reads the item, then executes write and the put of its history record in one transaction on
+// the condition that the item did not change since the read, trying again when it did. A failed condition of
+// write is returned as the ConditionalCheckFailedException holding the stored item that a single write returns.
+func (d DynamoDBRepository) writeWithHistory(ctx context.Context, request events.APIGatewayProxyRequest, operation string, write types.TransactWriteItem, change historyChange) error {
+	for attempt := 1; ; attempt++ {
+		before, err := d.itemBefore(ctx, change.key)
+		if err != nil {
+			return err
+		}
+		record, err := d.historyRecord(ctx, request, change, before, change.after(before))
+		if err != nil {
+			return err
+		}
+		unchangedWrite, err := withCondition(write, d.unchangedCondition(before))
+		if err != nil {
+			return err
+		}
+		transaction := NewTransaction().add(operation, unchangedWrite).Put(d.audit.HistoryTable, record, historyCondition())
+		input, err := transaction.build(d.table)
+		if err != nil {
+			return err
+		}
+		_, err = d.client.TransactWriteItems(ctx, input)
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) != 0 && aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			stored := canceled.CancellationReasons[0].Item
+			if attempt < maxHistoryAttempts && len(streams.ChangedAttributes(before, stored)) != 0 {
+				continue
+			}
+			return &types.ConditionalCheckFailedException{Message: canceled.CancellationReasons[0].Message, Item: stored}
+		}
+		if err != nil {
+			return newTransactionCanceledError(input, transaction.operations, err)
+		}
+		return nil
+	}
+}
+
+// keyOfItem builds the key of an item from the key schema of the repository.
+func (d DynamoDBRepository) keyOfItem(item map[string]types.AttributeValue) Key {
+	key := NewKey(d.partitionKeyName, item[d.partitionKeyName])
+	if len(d.sortKeyName) != 0 {
+		key = key.WithSortKey(d.sortKeyName, item[d.sortKeyName])
+	}
+	return key
+}
+
+// itemAfterUpdate reads the item an update recorded in the history has written, since updates made in a
+// transaction return no values, and selects the attributes of returnValues.
+func (d DynamoDBRepository) itemAfterUpdate(ctx context.Context, key Key, returnValues types.ReturnValue, touched map[string]string) (map[string]types.AttributeValue, error) {
+	response, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            key.AttributeMap(),
+		TableName:      aws.String(d.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if returnValues == types.ReturnValueAllNew {
+		return response.Item, nil
+	}
+	attributes := make(map[string]types.AttributeValue)
+	for name, value := range response.Item {
+		if isTouched(touched, name) {
+			attributes[name] = value
+		}
+	}
+	return attributes, nil
+}
+
+// isTouched reports whether an attribute appears in the attribute names of an update expression.
+func isTouched(touched map[string]string, name string) bool {
+	for _, touchedName := range touched {
+		if touchedName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package dynamodbcore_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newAuditedRepository(t *testing.T, opts ...dynamodbcore.Option) (*dynamodbcore.DynamoDBRepository, *dynamodbtest.Client) {
+	t.Helper()
+	client := dynamodbtest.NewClient(
+		dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"},
+		dynamodbtest.TableDefinition{Name: "payments-history", PartitionKey: dynamodbcore.HistoryKeyAttribute, SortKey: dynamodbcore.HistorySortKeyAttribute},
+	)
+	opts = append([]dynamodbcore.Option{
+		dynamodbcore.WithClient(client),
+		dynamodbcore.WithCursorSecret([]byte("test-secret")),
+		dynamodbcore.WithKeySchema("id", ""),
+		dynamodbcore.WithVersionAttribute("version"),
+		dynamodbcore.WithAudit(dynamodbcore.AuditPolicy{HistoryTable: "payments-history"}),
+	}, opts...)
+	repository, err := dynamodbcore.NewDynamoDBRepository("payments", "us-east-1", opts...)
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository error = %v", err)
+	}
+	return repository, client
+}
+
+func historyRecords(t *testing.T, client *dynamodbtest.Client) []dynamodbcore.HistoryRecord {
+	t.Helper()
+	var records []dynamodbcore.HistoryRecord
+	if err := attributevalue.UnmarshalListOfMaps(client.Items("payments-history"), &records); err != nil {
+		t.Fatalf("UnmarshalListOfMaps error = %v", err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ChangeID < records[j].ChangeID })
+	return records
+}
+
+func TestAuditHistory(t *testing.T) {
+	repository, client := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+	key := dynamodbcore.NewStringKey("id", "p1")
+
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PENDING", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	if err := repository.UpdateItemCore(ctx, request, payment{ID: "p1", Status: "PAID", Amount: 100, Version: 1}, key, nil); err != nil {
+		t.Fatalf("UpdateItemCore error = %v", err)
+	}
+	// A failed write records nothing.
+	if err := repository.UpdateItemCore(ctx, request, payment{ID: "p1", Status: "REFUNDED", Version: 1}, key, nil); !errors.Is(err, dynamodbcore.ErrVersionConflict) {
+		t.Fatalf("UpdateItemCore with a stale version error = %v, want %v", err, dynamodbcore.ErrVersionConflict)
+	}
+	if err := repository.DeleteItemCore(ctx, request, key); err != nil {
+		t.Fatalf("DeleteItemCore error = %v", err)
+	}
+	// Deleting a missing item records nothing either.
+	if err := repository.DeleteItemCore(ctx, request, key); err != nil {
+		t.Fatalf("DeleteItemCore of a missing item error = %v", err)
+	}
+
+	records := historyRecords(t, client)
+	operations := make([]string, 0, len(records))
+	for _, record := range records {
+		operations = append(operations, record.Operation)
+		if record.ItemKey != "payments#p1" || record.ChangedBy != "system" {
+			t.Errorf("%s record key = %s, changed by %s", record.Operation, record.ItemKey, record.ChangedBy)
+		}
+	}
+	want := []string{dynamodbcore.HistoryOperationCreate, dynamodbcore.HistoryOperationUpdate, dynamodbcore.HistoryOperationDelete}
+	if !reflect.DeepEqual(operations, want) {
+		t.Fatalf("history operations = %v, want %v", operations, want)
+	}
+	create := records[0]
+	if len(create.Before) != 0 || create.After["status"] != "PENDING" {
+		t.Errorf("CREATE record before = %v, after = %v, want no before and status PENDING after", create.Before, create.After)
+	}
+	update := records[1]
+	if update.Before["status"] != "PENDING" || update.Before["version"] != 1.0 || update.After["status"] != "PAID" || update.After["version"] != 2.0 {
+		t.Errorf("UPDATE record before = %v, after = %v, want status PENDING to PAID and version 1 to 2", update.Before, update.After)
+	}
+	// updatedAt only changes when the update falls in another second than the create.
+	var changed []string
+	for _, name := range update.ChangedAttributes {
+		if name != "updatedAt" {
+			changed = append(changed, name)
+		}
+	}
+	if wantChanged := []string{"status", "version"}; !reflect.DeepEqual(changed, wantChanged) {
+		t.Errorf("UPDATE record changed attributes = %v, want %v", update.ChangedAttributes, wantChanged)
+	}
+	deletion := records[2]
+	if deletion.Before["status"] != "PAID" || deletion.Before["amount"] != 100.0 || len(deletion.After) != 0 {
+		t.Errorf("DELETE record before = %v, after = %v, want the deleted item before and nothing after", deletion.Before, deletion.After)
+	}
+}
+
+// concurrentClient changes the item once right before the first transaction, as a concurrent writer would.
+type concurrentClient struct {
+	*dynamodbtest.Client
+	changed bool
+}
+
+func (c *concurrentClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if !c.changed {
+		c.changed = true
+		if _, err := c.Client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String("payments"), Item: paymentItem("p1", "AUTHORIZED", 2)}); err != nil {
+			return nil, err
+		}
+	}
+	return c.Client.TransactWriteItems(ctx, params, optFns...)
+}
+
+func TestAuditHistoryConcurrentWrite(t *testing.T) {
+	repository, client := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PENDING", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	concurrent, err := dynamodbcore.NewDynamoDBRepository("payments", "us-east-1",
+		dynamodbcore.WithClient(&concurrentClient{Client: client}),
+		dynamodbcore.WithCursorSecret([]byte("test-secret")),
+		dynamodbcore.WithKeySchema("id", ""),
+		dynamodbcore.WithVersionAttribute("version"),
+		dynamodbcore.WithAudit(dynamodbcore.AuditPolicy{HistoryTable: "payments-history"}),
+	)
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository error = %v", err)
+	}
+
+	// The item changes between the read of its previous values and the update, which is tried again.
+	patch := map[string]interface{}{"status": "PAID"}
+	if err := concurrent.UpdateItemCore(ctx, request, patch, dynamodbcore.NewStringKey("id", "p1"), nil, dynamodbcore.WithPatch()); err != nil {
+		t.Fatalf("UpdateItemCore error = %v", err)
+	}
+	records := historyRecords(t, client)
+	if len(records) != 2 {
+		t.Fatalf("history records = %+v, want the CREATE and one UPDATE", records)
+	}
+	update := records[1]
+	if update.Before["status"] != "AUTHORIZED" || update.Before["version"] != 2.0 || update.After["status"] != "PAID" || update.After["version"] != 3.0 {
+		t.Errorf("UPDATE record before = %v, after = %v, want status AUTHORIZED to PAID and version 2 to 3", update.Before, update.After)
+	}
+}
+
+func TestAuditStampPut(t *testing.T) {
+	repository, client := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PENDING", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	if _, found := client.Items("payments")[0]["createdAt"]; !found {
+		t.Fatalf("create did not stamp createdAt")
+	}
+
+	createdAt := client.Items("payments")[0]["createdAt"]
+
+	// An upsert lacking the creation attributes keeps those of the stored item.
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PAID", 1)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	item := client.Items("payments")[0]
+	if !reflect.DeepEqual(item["createdAt"], createdAt) || item["createdBy"] == nil {
+		t.Errorf("upsert creation attributes = %v, %v, want createdAt %v", item["createdAt"], item["createdBy"], createdAt)
+	}
+	if _, found := item["updatedAt"]; !found {
+		t.Errorf("upsert did not stamp updatedAt")
+	}
+
+	// An upsert creating the item stamps them.
+	if err := repository.PutItemCore(ctx, request, paymentItem("p2", "PENDING", 0)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	for _, stored := range client.Items("payments") {
+		if id := stored["id"].(*types.AttributeValueMemberS).Value; id == "p2" && (stored["createdAt"] == nil || stored["createdBy"] == nil) {
+			t.Errorf("creating upsert did not stamp the creation attributes: %v", stored)
+		}
+	}
+	transaction := dynamodbcore.NewTransaction().PutItem(ctx, request, repository, paymentItem("p3", "PENDING", 0))
+	if err := repository.TransactWriteCore(ctx, request, transaction); err != nil {
+		t.Fatalf("TransactWriteCore error = %v", err)
+	}
+	for _, stored := range client.Items("payments") {
+		if id := stored["id"].(*types.AttributeValueMemberS).Value; id == "p3" && (stored["createdAt"] == nil || stored["createdBy"] == nil) {
+			t.Errorf("creating upsert in a transaction did not stamp the creation attributes: %v", stored)
+		}
+	}
+}
+
+func TestAuditRejectsUnauditedWrites(t *testing.T) {
+	repository, client := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+
+	transaction := dynamodbcore.NewTransaction().Put("", paymentItem("p1", "PENDING", 0))
+	if err := repository.TransactWriteCore(ctx, request, transaction); !errors.Is(err, dynamodbcore.ErrValidation) {
+		t.Errorf("TransactWriteCore with a plain put error = %v, want %v", err, dynamodbcore.ErrValidation)
+	}
+	if err := repository.BatchWriteCore(ctx, request, []map[string]types.AttributeValue{paymentItem("p1", "PENDING", 0)}, nil); !errors.Is(err, dynamodbcore.ErrValidation) {
+		t.Errorf("BatchWriteCore error = %v, want %v", err, dynamodbcore.ErrValidation)
+	}
+	if items := client.Items("payments"); len(items) != 0 {
+		t.Fatalf("rejected writes stored %d items", len(items))
+	}
+
+	transaction = dynamodbcore.NewTransaction().PutItem(ctx, request, repository, paymentItem("p1", "PENDING", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate))
+	if err := repository.TransactWriteCore(ctx, request, transaction); err != nil {
+		t.Fatalf("TransactWriteCore with PutItem error = %v", err)
+	}
+	if records := historyRecords(t, client); len(records) != 1 || records[0].Operation != dynamodbcore.HistoryOperationCreate {
+		t.Errorf("history records = %v, want one CREATE", records)
+	}
+}
+
+func TestAuditReturnValues(t *testing.T) {
+	repository, _ := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PENDING", 0)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	key := dynamodbcore.NewStringKey("id", "p1")
+
+	var updated payment
+	patch := map[string]interface{}{"status": "PAID"}
+	if err := repository.UpdateItemCore(ctx, request, patch, key, nil, dynamodbcore.WithPatch(), dynamodbcore.WithReturnValues(types.ReturnValueAllNew, &updated)); err != nil {
+		t.Fatalf("UpdateItemCore error = %v", err)
+	}
+	if updated.Status != "PAID" || updated.Version != 2 {
+		t.Errorf("returned item = %+v, want status PAID and version 2", updated)
+	}
+
+	var old payment
+	err := repository.UpdateItemCore(ctx, request, patch, key, nil, dynamodbcore.WithPatch(), dynamodbcore.WithReturnValues(types.ReturnValueAllOld, &old))
+	if !errors.Is(err, dynamodbcore.ErrValidation) {
+		t.Errorf("UpdateItemCore returning old values error = %v, want %v", err, dynamodbcore.ErrValidation)
+	}
+}
+
+func TestAuditNestedPatch(t *testing.T) {
+	repository, client := newAuditedRepository(t)
+	ctx := context.Background()
+	request := events.APIGatewayProxyRequest{}
+	if err := repository.PutItemCore(ctx, request, paymentItem("p1", "PENDING", 0)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	patch := map[string]interface{}{"billing.address.city": "Quito"}
+	if err := repository.UpdateItemCore(ctx, request, patch, dynamodbcore.NewStringKey("id", "p1"), nil, dynamodbcore.WithPatch()); err != nil {
+		t.Fatalf("UpdateItemCore error = %v", err)
+	}
+	records := historyRecords(t, client)
+	if len(records) != 2 || records[1].Before["billing.address.city"] != nil || records[1].After["billing.address.city"] != "Quito" {
+		t.Errorf("history records = %+v, want the PUT and an UPDATE of billing.address.city", records)
+	}
+}
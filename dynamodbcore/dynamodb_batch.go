@@ -0,0 +1,269 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBatchGetItems is the maximum number of keys DynamoDB accepts in one BatchGetItem call.
+	maxBatchGetItems = 100
+	// maxBatchWriteItems is the maximum number of requests DynamoDB accepts in one BatchWriteItem call.
+	maxBatchWriteItems = 25
+	// defaultBatchConcurrency is the default number of chunks processed at the same time.
+	defaultBatchConcurrency = 4
+)
+
+// BatchRetryPolicy defines how unprocessed keys and items are retried.
+// Retries stop when everything is processed, when MaxDuration elapses or when the context ends.
+type BatchRetryPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxDuration    time.Duration
+}
+
+// errorAuditedBatchWrite is returned when batch writes are made on an audited table.
+var errorAuditedBatchWrite = errors.New("batch writes cannot be audited, use PutItemCore, DeleteItemCore or TransactionBuilder.PutItem")
+
+// errorSoftDeletedBatchDelete is returned when batch deletes are made on a table with soft delete.
+var errorSoftDeletedBatchDelete = errors.New("batch deletes cannot soft delete, use DeleteItemCore")
+
+// defaultBatchRetryPolicy is used when no BatchRetryPolicy is configured.
+var defaultBatchRetryPolicy = BatchRetryPolicy{
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	MaxDuration:    30 * time.Second,
+}
+
+// BatchGetCore get many items from DynamoDB, splitting the keys in chunks of 100.
+// Duplicated keys are requested once and the order of the result is not guaranteed.
+func (d DynamoDBRepository) BatchGetCore(ctx context.Context, request events.APIGatewayProxyRequest, keys []Key, opts ...ReadOption) ([]map[string]types.AttributeValue, error) {
+	logs.LogTrackingInfo("BatchGetCore", ctx, request)
+	options := d.readOptions(opts)
+	projection, projectionNames, errorProjection := options.projectionExpression()
+	if errorProjection != nil {
+		logs.LogTrackingError("BatchGetCore", "projectionExpression", ctx, request, errorProjection)
+		return nil, newOperationError("BatchGetCore", ErrValidation, errorProjection)
+	}
+	requestKeys := make([]map[string]types.AttributeValue, 0, len(keys))
+	seenKeys := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if errorValidateKey := key.Validate(); errorValidateKey != nil {
+			logs.LogTrackingError("BatchGetCore", "Validate", ctx, request, errorValidateKey)
+			return nil, wrapError("BatchGetCore", errorValidateKey)
+		}
+		fingerprint := fingerprintKey(key)
+		if seenKeys[fingerprint] {
+			continue
+		}
+		seenKeys[fingerprint] = true
+		requestKeys = append(requestKeys, key.AttributeMap())
+	}
+
+	var mutex sync.Mutex
+	items := make([]map[string]types.AttributeValue, 0, len(requestKeys))
+	chunks := chunkCount(len(requestKeys), maxBatchGetItems)
+	err := d.runBatchChunks(ctx, chunks, func(ctx context.Context, chunk int) error {
+		requestItems := map[string]types.KeysAndAttributes{
+			d.table: {
+				Keys:                     chunkOf(requestKeys, chunk, maxBatchGetItems),
+				ConsistentRead:           aws.Bool(options.consistentRead),
+				ProjectionExpression:     projection,
+				ExpressionAttributeNames: projectionNames,
+			},
+		}
+		return d.retryUnprocessed(ctx, func(ctx context.Context) (int, error) {
+			operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+			if errorTimeout != nil {
+				return 0, errorTimeout
+			}
+			defer cancel()
+			response, err := d.client.BatchGetItem(operationCtx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				return 0, err
+			}
+			mutex.Lock()
+			for _, item := range response.Responses[d.table] {
+				if options.includeDeleted || !d.isSoftDeleted(item) {
+					items = append(items, item)
+				}
+			}
+			mutex.Unlock()
+			requestItems = response.UnprocessedKeys
+			return len(requestItems[d.table].Keys), nil
+		})
+	})
+	if err != nil {
+		logs.LogTrackingError("BatchGetCore", "BatchGetItem", ctx, request, err)
+		return items, wrapError("BatchGetCore", err)
+	}
+	return items, nil
+}
+
+// BatchWriteCore put and delete many items in DynamoDB, splitting the requests in chunks of 25.
+// A batch must not contain more than one request for the same key. Batch writes cannot be conditioned,
+// so items are written as given: versions are neither checked nor incremented and no TTL is stamped.
+// Batch writes cannot be audited either, so they fail with ErrValidation with WithAudit, and deletes
+// cannot be soft deletes, so they fail with ErrValidation with WithSoftDelete.
+func (d DynamoDBRepository) BatchWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, puts []map[string]types.AttributeValue, deletes []Key) error {
+	logs.LogTrackingInfo("BatchWriteCore", ctx, request)
+	if d.audit != nil {
+		logs.LogTrackingError("BatchWriteCore", "WithAudit", ctx, request, errorAuditedBatchWrite)
+		return newOperationError("BatchWriteCore", ErrValidation, errorAuditedBatchWrite)
+	}
+	if d.softDelete != nil && len(deletes) != 0 {
+		logs.LogTrackingError("BatchWriteCore", "WithSoftDelete", ctx, request, errorSoftDeletedBatchDelete)
+		return newOperationError("BatchWriteCore", ErrValidation, errorSoftDeletedBatchDelete)
+	}
+	writeRequests := make([]types.WriteRequest, 0, len(puts)+len(deletes))
+	for _, item := range puts {
+		writeRequests = append(writeRequests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	for _, key := range deletes {
+		if errorValidateKey := key.Validate(); errorValidateKey != nil {
+			logs.LogTrackingError("BatchWriteCore", "Validate", ctx, request, errorValidateKey)
+			return wrapError("BatchWriteCore", errorValidateKey)
+		}
+		writeRequests = append(writeRequests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key.AttributeMap()}})
+	}
+
+	chunks := chunkCount(len(writeRequests), maxBatchWriteItems)
+	err := d.runBatchChunks(ctx, chunks, func(ctx context.Context, chunk int) error {
+		requestItems := map[string][]types.WriteRequest{
+			d.table: chunkOf(writeRequests, chunk, maxBatchWriteItems),
+		}
+		return d.retryUnprocessed(ctx, func(ctx context.Context) (int, error) {
+			operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+			if errorTimeout != nil {
+				return 0, errorTimeout
+			}
+			defer cancel()
+			response, err := d.client.BatchWriteItem(operationCtx, &dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+			if err != nil {
+				return 0, err
+			}
+			requestItems = response.UnprocessedItems
+			return len(requestItems[d.table]), nil
+		})
+	})
+	if err != nil {
+		logs.LogTrackingError("BatchWriteCore", "BatchWriteItem", ctx, request, err)
+		return wrapError("BatchWriteCore", err)
+	}
+	return nil
+}
+
+// runBatchChunks processes chunks with at most batchConcurrency workers, stopping at the first error.
+func (d DynamoDBRepository) runBatchChunks(ctx context.Context, chunks int, processChunk func(ctx context.Context, chunk int) error) error {
+	concurrency := d.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	return runConcurrently(ctx, concurrency, chunks, processChunk)
+}
+
+// runConcurrently runs tasks with at most concurrency workers, canceling the others at the first error.
+func runConcurrently(ctx context.Context, concurrency int, tasks int, processTask func(ctx context.Context, task int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make(chan int)
+	var waitGroup sync.WaitGroup
+	var once sync.Once
+	var firstError error
+	for worker := 0; worker < concurrency && worker < tasks; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for task := range pending {
+				if err := processTask(ctx, task); err != nil {
+					once.Do(func() {
+						firstError = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+sendTasks:
+	for task := 0; task < tasks; task++ {
+		select {
+		case pending <- task:
+		case <-ctx.Done():
+			break sendTasks
+		}
+	}
+	close(pending)
+	waitGroup.Wait()
+
+	if firstError != nil {
+		return firstError
+	}
+	return ctx.Err()
+}
+
+// retryUnprocessed calls attempt until it reports no remaining items, backing off exponentially with jitter.
+func (d DynamoDBRepository) retryUnprocessed(ctx context.Context, attempt func(ctx context.Context) (int, error)) error {
+	policy := d.batchRetryPolicy
+	deadline := time.Now().Add(policy.MaxDuration)
+	backoff := policy.InitialBackoff
+	for {
+		remaining, err := attempt(ctx)
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return nil
+		}
+
+		wait := jitteredBackoff(backoff)
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%w: %d items left after %s", ErrUnprocessedItems, remaining, policy.MaxDuration)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %d items left: %v", ErrUnprocessedItems, remaining, ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// jitteredBackoff returns a random wait between half and all of backoff.
+func jitteredBackoff(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// chunkCount returns how many chunks of chunkSize are needed for total elements.
+func chunkCount(total int, chunkSize int) int {
+	return (total + chunkSize - 1) / chunkSize
+}
+
+// chunkOf returns the chunk with the given index.
+func chunkOf[T any](elements []T, chunk int, chunkSize int) []T {
+	start := chunk * chunkSize
+	end := start + chunkSize
+	if end > len(elements) {
+		end = len(elements)
+	}
+	return elements[start:end]
+}
@@ -0,0 +1,496 @@
+package dynamodbcore
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheSize is the number of entries kept when no size is configured.
+	defaultCacheSize = 1000
+	// defaultCacheTTL is how long entries are kept when no TTL is configured.
+	defaultCacheTTL = time.Minute
+)
+
+// CacheStats counts the lookups of a CachedRepository since it was created.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// CachedRepository decorates a CoreRepository with an in-process LRU cache of item reads and query pages.
+// Create it once at init so the cache survives across warm invocations of the Lambda.
+// Writes made through the decorator invalidate the cached item and every cached query; writes made elsewhere
+// are only seen once the entries expire, so keep the TTL short for data that other services change.
+// Consistent reads bypass the cache, and the other operations are passed through uncached.
+type CachedRepository struct {
+	repository CoreRepository
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	recency *list.List
+	stats   CacheStats
+	// generation changes on every invalidation, so reads that started before it are not cached.
+	generation uint64
+}
+
+// cacheEntry is a cached result and, for item reads, the key of the item it holds.
+type cacheEntry struct {
+	cacheKey  string
+	itemKey   *Key
+	value     interface{}
+	expiresAt time.Time
+}
+
+// CacheOption configures a CachedRepository.
+type CacheOption func(*CachedRepository)
+
+// WithCacheSize sets how many entries are kept before the least recently used ones are evicted.
+func WithCacheSize(maxEntries int) CacheOption {
+	return func(c *CachedRepository) {
+		c.maxEntries = maxEntries
+	}
+}
+
+// WithCacheTTL sets how long entries are served before being read again from DynamoDB.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *CachedRepository) {
+		c.ttl = ttl
+	}
+}
+
+var _ CoreRepository = (*CachedRepository)(nil)
+
+// NewCachedRepository wraps repository with a read-through cache.
+func NewCachedRepository(repository CoreRepository, opts ...CacheOption) *CachedRepository {
+	cachedRepository := &CachedRepository{
+		repository: repository,
+		maxEntries: defaultCacheSize,
+		ttl:        defaultCacheTTL,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		recency:    list.New(),
+	}
+	for _, opt := range opts {
+		opt(cachedRepository)
+	}
+	return cachedRepository
+}
+
+// Stats returns the hit, miss and eviction counters and the number of cached entries.
+func (c *CachedRepository) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Entries = c.recency.Len()
+	return stats
+}
+
+// Purge removes every entry, for example after items were changed without going through the decorator.
+func (c *CachedRepository) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.recency.Init()
+	c.generation++
+}
+
+// PutItemCore stores the item and invalidates its cached reads and every cached query.
+func (c *CachedRepository) PutItemCore(ctx context.Context, request events.APIGatewayProxyRequest, item map[string]types.AttributeValue, opts ...PutOption) error {
+	defer c.invalidate(func(key Key) bool {
+		for _, attribute := range key.attributes() {
+			if value, found := item[attribute.Name]; !found || fingerprintValue(value) != fingerprintValue(attribute.Value) {
+				return false
+			}
+		}
+		return true
+	})
+	return c.repository.PutItemCore(ctx, request, item, opts...)
+}
+
+// GetItemCore reads the item from the cache, or from DynamoDB when it is missing or expired.
+func (c *CachedRepository) GetItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...ReadOption) (*dynamodb.GetItemOutput, error) {
+	options := newReadOptions(opts)
+	if options.consistentRead {
+		return c.repository.GetItemCore(ctx, request, key, opts...)
+	}
+	cacheKey := fmt.Sprintf("get|%s|%q|%t", fingerprintKey(key), options.projection, options.includeDeleted)
+	cached, found, generation := c.lookup(cacheKey)
+	if found {
+		logs.LogTrackingInfo("GetItemCore cache hit", ctx, request)
+		return copyGetItemOutput(cached.(*dynamodb.GetItemOutput)), nil
+	}
+	response, err := c.repository.GetItemCore(ctx, request, key, opts...)
+	if err != nil {
+		return response, err
+	}
+	c.store(cacheKey, generation, &key, copyGetItemOutput(response))
+	return response, nil
+}
+
+// DeleteItemCore deletes the item and invalidates its cached reads and every cached query.
+func (c *CachedRepository) DeleteItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...DeleteOption) error {
+	defer c.invalidateKey(key)
+	return c.repository.DeleteItemCore(ctx, request, key, opts...)
+}
+
+// UpdateItemCore updates the item and invalidates its cached reads and every cached query.
+func (c *CachedRepository) UpdateItemCore(ctx context.Context, request events.APIGatewayProxyRequest, itemObject interface{}, key Key, skipFields []string, opts ...UpdateOption) error {
+	defer c.invalidateKey(key)
+	return c.repository.UpdateItemCore(ctx, request, itemObject, key, skipFields, opts...)
+}
+
+// GetItemByFieldCore reads the query result from the cache, or from DynamoDB when it is missing or expired.
+func (c *CachedRepository) GetItemByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, fieldNameFilterStatus string, fieldValueFilterStatus string) (*dynamodb.QueryOutput, error) {
+	cacheKey := fmt.Sprintf("field|%q", []string{fieldNameFilterByID, fieldValueFilterByID, globalSecondaryIndex, fieldNameFilterStatus, fieldValueFilterStatus})
+	cached, found, generation := c.lookup(cacheKey)
+	if found {
+		logs.LogTrackingInfo("GetItemByFieldCore cache hit", ctx, request)
+		return copyQueryOutput(cached.(*dynamodb.QueryOutput)), nil
+	}
+	response, err := c.repository.GetItemByFieldCore(ctx, request, fieldNameFilterByID, fieldValueFilterByID, globalSecondaryIndex, fieldNameFilterStatus, fieldValueFilterStatus)
+	if err != nil {
+		return response, err
+	}
+	c.store(cacheKey, generation, nil, copyQueryOutput(response))
+	return response, nil
+}
+
+// GetItemsByFieldPageCore reads the page from the cache, or from DynamoDB when it is missing or expired.
+func (c *CachedRepository) GetItemsByFieldPageCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, cursor string) (*QueryPage, error) {
+	cacheKey := fmt.Sprintf("page|%q|%d", []string{fieldNameFilterByID, fieldValueFilterByID, globalSecondaryIndex, cursor}, pageSize)
+	return c.cachedPage(ctx, request, "GetItemsByFieldPageCore", cacheKey, func() (*QueryPage, error) {
+		return c.repository.GetItemsByFieldPageCore(ctx, request, fieldNameFilterByID, fieldValueFilterByID, globalSecondaryIndex, pageSize, cursor)
+	})
+}
+
+// GetAllItemsByFieldCore reads every page from DynamoDB without caching.
+func (c *CachedRepository) GetAllItemsByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, handlePage func(items []map[string]types.AttributeValue) error) error {
+	return c.repository.GetAllItemsByFieldCore(ctx, request, fieldNameFilterByID, fieldValueFilterByID, globalSecondaryIndex, pageSize, handlePage)
+}
+
+// QueryCore reads the page from the cache, or from DynamoDB when it is missing or expired.
+// Consistent queries bypass the cache.
+func (c *CachedRepository) QueryCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, cursor string) (*QueryPage, error) {
+	queryFingerprint, cacheable := fingerprintQuery(query)
+	if !cacheable {
+		return c.repository.QueryCore(ctx, request, query, cursor)
+	}
+	return c.cachedPage(ctx, request, "QueryCore", fmt.Sprintf("query|%s|%q", queryFingerprint, cursor), func() (*QueryPage, error) {
+		return c.repository.QueryCore(ctx, request, query, cursor)
+	})
+}
+
+// QueryAllCore reads every page from DynamoDB without caching.
+func (c *CachedRepository) QueryAllCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, handlePage func(items []map[string]types.AttributeValue) error) error {
+	return c.repository.QueryAllCore(ctx, request, query, handlePage)
+}
+
+// TransactWriteCore executes the transaction and, since it may write any item, purges the cache.
+func (c *CachedRepository) TransactWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder) error {
+	defer c.Purge()
+	return c.repository.TransactWriteCore(ctx, request, transaction)
+}
+
+// transactPut adds a put of item to transaction as the wrapped repository does. Executing the transaction
+// with TransactWriteCore purges the cache.
+func (c *CachedRepository) transactPut(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder, item map[string]types.AttributeValue, opts []PutOption) *TransactionBuilder {
+	return transaction.PutItem(ctx, request, c.repository, item, opts...)
+}
+
+// TransactGetCore reads the items from DynamoDB without caching.
+func (c *CachedRepository) TransactGetCore(ctx context.Context, request events.APIGatewayProxyRequest, items []TransactGetItem, opts ...ReadOption) ([]map[string]types.AttributeValue, error) {
+	return c.repository.TransactGetCore(ctx, request, items, opts...)
+}
+
+// BatchGetCore reads the items from DynamoDB without caching.
+func (c *CachedRepository) BatchGetCore(ctx context.Context, request events.APIGatewayProxyRequest, keys []Key, opts ...ReadOption) ([]map[string]types.AttributeValue, error) {
+	return c.repository.BatchGetCore(ctx, request, keys, opts...)
+}
+
+// BatchWriteCore writes the items and, since it may write many items, purges the cache.
+func (c *CachedRepository) BatchWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, puts []map[string]types.AttributeValue, deletes []Key) error {
+	defer c.Purge()
+	return c.repository.BatchWriteCore(ctx, request, puts, deletes)
+}
+
+// ScanCore scans the table without caching.
+func (c *CachedRepository) ScanCore(ctx context.Context, request events.APIGatewayProxyRequest, handleItems func(ctx context.Context, segment int32, items []map[string]types.AttributeValue) error, opts ...ScanOption) error {
+	return c.repository.ScanCore(ctx, request, handleItems, opts...)
+}
+
+// cachedPage reads a query page from the cache, or with read when it is missing or expired.
+func (c *CachedRepository) cachedPage(ctx context.Context, request events.APIGatewayProxyRequest, operation string, cacheKey string, read func() (*QueryPage, error)) (*QueryPage, error) {
+	cached, found, generation := c.lookup(cacheKey)
+	if found {
+		logs.LogTrackingInfo(operation+" cache hit", ctx, request)
+		page := *cached.(*QueryPage)
+		page.Items = copyItems(page.Items)
+		return &page, nil
+	}
+	page, err := read()
+	if err != nil {
+		return page, err
+	}
+	c.store(cacheKey, generation, nil, &QueryPage{Items: copyItems(page.Items), NextCursor: page.NextCursor})
+	return page, nil
+}
+
+// lookup returns the value cached under cacheKey unless it expired, counting the hit or miss,
+// and the generation to store the value read on a miss with.
+func (c *CachedRepository) lookup(cacheKey string) (interface{}, bool, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, found := c.entries[cacheKey]
+	if !found {
+		c.stats.Misses++
+		return nil, false, c.generation
+	}
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		c.stats.Misses++
+		return nil, false, c.generation
+	}
+	c.recency.MoveToFront(element)
+	c.stats.Hits++
+	return entry.value, true, c.generation
+}
+
+// store caches value under cacheKey, evicting the least recently used entries beyond the size limit.
+// The value is dropped when the cache was invalidated since its lookup, as it may predate the write.
+func (c *CachedRepository) store(cacheKey string, generation uint64, itemKey *Key, value interface{}) {
+	if c.maxEntries <= 0 || c.ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if element, found := c.entries[cacheKey]; found {
+		c.remove(element)
+	}
+	c.entries[cacheKey] = c.recency.PushFront(&cacheEntry{
+		cacheKey:  cacheKey,
+		itemKey:   itemKey,
+		value:     value,
+		expiresAt: c.now().Add(c.ttl),
+	})
+	for c.recency.Len() > c.maxEntries {
+		c.remove(c.recency.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidateKey removes the cached reads of the item stored at key and every cached query.
+func (c *CachedRepository) invalidateKey(key Key) {
+	itemFingerprint := fingerprintKey(key)
+	c.invalidate(func(cachedKey Key) bool {
+		return fingerprintKey(cachedKey) == itemFingerprint
+	})
+}
+
+// invalidate removes the cached reads of the items whose key matches and every cached query,
+// since any query may include the written item.
+func (c *CachedRepository) invalidate(matches func(key Key) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	for element := c.recency.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		if entry.itemKey == nil || matches(*entry.itemKey) {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+// remove removes an entry, with the lock held.
+func (c *CachedRepository) remove(element *list.Element) {
+	c.recency.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).cacheKey)
+}
+
+// copyGetItemOutput copies the response and its item, so callers cannot change the cached item.
+func copyGetItemOutput(response *dynamodb.GetItemOutput) *dynamodb.GetItemOutput {
+	output := *response
+	output.Item = copyItem(response.Item)
+	return &output
+}
+
+// copyQueryOutput copies the response, its items and its last evaluated key.
+func copyQueryOutput(response *dynamodb.QueryOutput) *dynamodb.QueryOutput {
+	output := *response
+	output.Items = copyItems(response.Items)
+	output.LastEvaluatedKey = copyItem(response.LastEvaluatedKey)
+	return &output
+}
+
+// copyItems copies the items of a page.
+func copyItems(items []map[string]types.AttributeValue) []map[string]types.AttributeValue {
+	if items == nil {
+		return nil
+	}
+	copied := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		copied = append(copied, copyItem(item))
+	}
+	return copied
+}
+
+// copyItem deep copies an item, down to its nested maps, lists, sets and binary values.
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	copied := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		copied[name] = copyValue(value)
+	}
+	return copied
+}
+
+// copyValue deep copies an attribute value.
+func copyValue(value types.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte(nil), v.Value...)}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string(nil), v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string(nil), v.Value...)}
+	case *types.AttributeValueMemberBS:
+		copied := make([][]byte, 0, len(v.Value))
+		for _, member := range v.Value {
+			copied = append(copied, append([]byte(nil), member...))
+		}
+		return &types.AttributeValueMemberBS{Value: copied}
+	case *types.AttributeValueMemberL:
+		copied := make([]types.AttributeValue, 0, len(v.Value))
+		for _, member := range v.Value {
+			copied = append(copied, copyValue(member))
+		}
+		return &types.AttributeValueMemberL{Value: copied}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	}
+	return value
+}
+
+// fingerprintQuery returns a string identifying the query, or false when it must not be cached.
+func fingerprintQuery(query *QueryBuilder) (string, bool) {
+	if query.consistent {
+		return "", false
+	}
+	input, err := query.build("")
+	if err != nil {
+		// Invalid queries reach the repository, which reports the error.
+		return "", false
+	}
+	var fingerprint strings.Builder
+	fmt.Fprintf(&fingerprint, "%q|%t|%t|%d", []string{aws.ToString(input.IndexName), aws.ToString(input.KeyConditionExpression),
+		aws.ToString(input.FilterExpression), aws.ToString(input.ProjectionExpression)}, query.descending, query.withDeleted, query.limit)
+	fingerprint.WriteString(fingerprintNames(input.ExpressionAttributeNames))
+	fingerprint.WriteString(fingerprintValue(&types.AttributeValueMemberM{Value: input.ExpressionAttributeValues}))
+	return fingerprint.String(), true
+}
+
+// fingerprintKey returns a string identifying a key.
+func fingerprintKey(key Key) string {
+	var fingerprint strings.Builder
+	for _, attribute := range key.attributes() {
+		fmt.Fprintf(&fingerprint, "%q=%s;", attribute.Name, fingerprintValue(attribute.Value))
+	}
+	return fingerprint.String()
+}
+
+// fingerprintNames returns a string identifying expression attribute names.
+func fingerprintNames(names map[string]string) string {
+	placeholders := make([]string, 0, len(names))
+	for placeholder := range names {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Strings(placeholders)
+	var fingerprint strings.Builder
+	for _, placeholder := range placeholders {
+		fmt.Fprintf(&fingerprint, "|%s=%q", placeholder, names[placeholder])
+	}
+	return fingerprint.String()
+}
+
+// fingerprintValue returns a string identifying an attribute value and its type.
+func fingerprintValue(value types.AttributeValue) string {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return fmt.Sprintf("S%q", v.Value)
+	case *types.AttributeValueMemberN:
+		return fmt.Sprintf("N%q", v.Value)
+	case *types.AttributeValueMemberB:
+		return "B" + base64.StdEncoding.EncodeToString(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return fmt.Sprintf("BOOL%t", v.Value)
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberSS:
+		return fmt.Sprintf("SS%q", sortedCopy(v.Value))
+	case *types.AttributeValueMemberNS:
+		return fmt.Sprintf("NS%q", sortedCopy(v.Value))
+	case *types.AttributeValueMemberBS:
+		encoded := make([]string, 0, len(v.Value))
+		for _, element := range v.Value {
+			encoded = append(encoded, base64.StdEncoding.EncodeToString(element))
+		}
+		return fmt.Sprintf("BS%q", sortedCopy(encoded))
+	case *types.AttributeValueMemberL:
+		elements := make([]string, 0, len(v.Value))
+		for _, element := range v.Value {
+			elements = append(elements, fingerprintValue(element))
+		}
+		return "L[" + strings.Join(elements, ",") + "]"
+	case *types.AttributeValueMemberM:
+		names := make([]string, 0, len(v.Value))
+		for name := range v.Value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		elements := make([]string, 0, len(names))
+		for _, name := range names {
+			elements = append(elements, fmt.Sprintf("%q:%s", name, fingerprintValue(v.Value[name])))
+		}
+		return "M{" + strings.Join(elements, ",") + "}"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// sortedCopy returns the elements of a set in order.
+func sortedCopy(elements []string) []string {
+	sorted := append([]string{}, elements...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,106 @@
+package dynamodbcore
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+	"sync"
+)
+
+// checkpointKeyAttribute is the partition key of the table used by DynamoDBCheckpointStore.
+const checkpointKeyAttribute = "checkpointID"
+
+// ScanCheckpoint records how far a segment of a scan went.
+type ScanCheckpoint struct {
+	Segment       int32  `dynamodbav:"segment"`
+	TotalSegments int32  `dynamodbav:"totalSegments"`
+	Cursor        string `dynamodbav:"cursor"`
+	Done          bool   `dynamodbav:"done"`
+}
+
+// CheckpointStore persists the progress of scans so they can resume after an interruption.
+type CheckpointStore interface {
+	LoadCheckpoint(ctx context.Context, scanID string, segment int32) (ScanCheckpoint, bool, error)
+	SaveCheckpoint(ctx context.Context, scanID string, checkpoint ScanCheckpoint) error
+}
+
+// MemoryCheckpointStore keeps checkpoints in memory, for tests and scans that run in a single invocation.
+type MemoryCheckpointStore struct {
+	mutex       sync.Mutex
+	checkpoints map[string]ScanCheckpoint
+}
+
+// NewMemoryCheckpointStore creates an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]ScanCheckpoint)}
+}
+
+// LoadCheckpoint returns the checkpoint of a segment, if any.
+func (s *MemoryCheckpointStore) LoadCheckpoint(_ context.Context, scanID string, segment int32) (ScanCheckpoint, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	checkpoint, found := s.checkpoints[checkpointID(scanID, segment)]
+	return checkpoint, found, nil
+}
+
+// SaveCheckpoint stores the checkpoint of a segment.
+func (s *MemoryCheckpointStore) SaveCheckpoint(_ context.Context, scanID string, checkpoint ScanCheckpoint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.checkpoints[checkpointID(scanID, checkpoint.Segment)] = checkpoint
+	return nil
+}
+
+// DynamoDBCheckpointStore keeps checkpoints in a DynamoDB table whose partition key is the string attribute checkpointID.
+type DynamoDBCheckpointStore struct {
+	client DynamoDBClientInterface
+	table  string
+}
+
+// NewDynamoDBCheckpointStore creates a DynamoDBCheckpointStore on the given table.
+func NewDynamoDBCheckpointStore(client DynamoDBClientInterface, tableName string) *DynamoDBCheckpointStore {
+	return &DynamoDBCheckpointStore{client: client, table: tableName}
+}
+
+// LoadCheckpoint returns the checkpoint of a segment, if any.
+func (s *DynamoDBCheckpointStore) LoadCheckpoint(ctx context.Context, scanID string, segment int32) (ScanCheckpoint, bool, error) {
+	response, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]types.AttributeValue{checkpointKeyAttribute: StringValue(checkpointID(scanID, segment))},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return ScanCheckpoint{}, false, err
+	}
+	if len(response.Item) == 0 {
+		return ScanCheckpoint{}, false, nil
+	}
+	var checkpoint ScanCheckpoint
+	if errorUnmarshal := attributevalue.UnmarshalMap(response.Item, &checkpoint); errorUnmarshal != nil {
+		return ScanCheckpoint{}, false, fmt.Errorf("unmarshal checkpoint: %w", errorUnmarshal)
+	}
+	return checkpoint, true, nil
+}
+
+// SaveCheckpoint stores the checkpoint of a segment.
+func (s *DynamoDBCheckpointStore) SaveCheckpoint(ctx context.Context, scanID string, checkpoint ScanCheckpoint) error {
+	item, err := attributevalue.MarshalMap(checkpoint)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+	item[checkpointKeyAttribute] = StringValue(checkpointID(scanID, checkpoint.Segment))
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return err
+}
+
+// checkpointID identifies the checkpoint of a segment.
+func checkpointID(scanID string, segment int32) string {
+	return scanID + "#" + strconv.Itoa(int(segment))
+}
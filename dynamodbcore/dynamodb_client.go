@@ -2,6 +2,7 @@ package dynamodbcore
 
 import (
 	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
@@ -12,6 +13,11 @@ type DynamoDBClientInterface interface {
 	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
 	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
 	GetItemByField(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
 }
 
 // DynamoDBClient implements the DynamoDBClientInterface interface using the actual DynamoDB client.
@@ -19,6 +25,13 @@ type DynamoDBClient struct {
 	client *dynamodb.Client
 }
 
+// NewDynamoDBClient creates a DynamoDBClient from an AWS config so it can be shared across repositories.
+func NewDynamoDBClient(cfg aws.Config, optFns ...func(*dynamodb.Options)) *DynamoDBClient {
+	return &DynamoDBClient{
+		client: dynamodb.NewFromConfig(cfg, optFns...),
+	}
+}
+
 // PutItem implements DynamoDB's PutItem operation.
 func (c *DynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
 	return c.client.PutItem(ctx, params, optFns...)
@@ -43,3 +56,28 @@ func (c *DynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.Update
 func (c *DynamoDBClient) GetItemByField(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
 	return c.client.Query(ctx, params, optFns...)
 }
+
+// TransactWriteItems implements DynamoDB's TransactWriteItems operation.
+func (c *DynamoDBClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return c.client.TransactWriteItems(ctx, params, optFns...)
+}
+
+// TransactGetItems implements DynamoDB's TransactGetItems operation.
+func (c *DynamoDBClient) TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error) {
+	return c.client.TransactGetItems(ctx, params, optFns...)
+}
+
+// BatchGetItem implements DynamoDB's BatchGetItem operation.
+func (c *DynamoDBClient) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return c.client.BatchGetItem(ctx, params, optFns...)
+}
+
+// BatchWriteItem implements DynamoDB's BatchWriteItem operation.
+func (c *DynamoDBClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return c.client.BatchWriteItem(ctx, params, optFns...)
+}
+
+// Scan implements DynamoDB's Scan operation.
+func (c *DynamoDBClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return c.client.Scan(ctx, params, optFns...)
+}
@@ -0,0 +1,109 @@
+package dynamodbcore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/constantscore"
+	"strings"
+)
+
+// errorMissingCursorSecret is returned when cursors would be signed with an empty key, which anyone can forge.
+var errorMissingCursorSecret = errors.New("cursor secret is required, set " + constantscore.CursorSecretEnv + " or use WithCursorSecret")
+
+// cursorSeparator separates the encoded payload from its signature.
+const cursorSeparator = "."
+
+// cursorAttribute is the serializable form of a key attribute inside a cursor.
+type cursorAttribute struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+// EncodeCursor converts a LastEvaluatedKey into an opaque, signed cursor.
+func EncodeCursor(lastEvaluatedKey map[string]types.AttributeValue, secret []byte) (string, error) {
+	if len(lastEvaluatedKey) == 0 {
+		return "", nil
+	}
+	if len(secret) == 0 {
+		return "", errorMissingCursorSecret
+	}
+
+	attributes := make(map[string]cursorAttribute, len(lastEvaluatedKey))
+	for name, value := range lastEvaluatedKey {
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			attributes[name] = cursorAttribute{Type: "S", Value: v.Value}
+		case *types.AttributeValueMemberN:
+			attributes[name] = cursorAttribute{Type: "N", Value: v.Value}
+		case *types.AttributeValueMemberB:
+			attributes[name] = cursorAttribute{Type: "B", Value: base64.StdEncoding.EncodeToString(v.Value)}
+		default:
+			return "", fmt.Errorf("unsupported key attribute type %T for %s", value, name)
+		}
+	}
+
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + cursorSeparator + signCursor(encodedPayload, secret), nil
+}
+
+// DecodeCursor validates the signature of a cursor and converts it back into an ExclusiveStartKey.
+func DecodeCursor(cursor string, secret []byte) (map[string]types.AttributeValue, error) {
+	if len(cursor) == 0 {
+		return nil, nil
+	}
+	if len(secret) == 0 {
+		return nil, errorMissingCursorSecret
+	}
+
+	encodedPayload, signature, found := strings.Cut(cursor, cursorSeparator)
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+	if !hmac.Equal([]byte(signature), []byte(signCursor(encodedPayload, secret))) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	attributes := make(map[string]cursorAttribute)
+	if err := json.Unmarshal(payload, &attributes); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]types.AttributeValue, len(attributes))
+	for name, attribute := range attributes {
+		switch attribute.Type {
+		case "S":
+			key[name] = &types.AttributeValueMemberS{Value: attribute.Value}
+		case "N":
+			key[name] = &types.AttributeValueMemberN{Value: attribute.Value}
+		case "B":
+			value, errDecode := base64.StdEncoding.DecodeString(attribute.Value)
+			if errDecode != nil {
+				return nil, ErrInvalidCursor
+			}
+			key[name] = &types.AttributeValueMemberB{Value: value}
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return key, nil
+}
+
+// signCursor computes the HMAC-SHA256 signature of an encoded cursor payload.
+func signCursor(encodedPayload string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,385 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"github.com/diegocabrera89/ms-payment-core/tracking"
+	"reflect"
+)
+
+// DefaultEntityTypeAttribute is the attribute holding the entity type when none is configured.
+const DefaultEntityTypeAttribute = "entityType"
+
+// EntityDefinition describes how an entity is stored in a single-table design: the templates of its
+// table key, the overloaded index keys it fills and the entity type stamped on its items.
+// The key attribute names of the table are those of WithKeySchema.
+type EntityDefinition struct {
+	// Name is the entity type stored in TypeAttribute, for example "Customer".
+	Name          string
+	TypeAttribute string
+	PartitionKey  KeyTemplate
+	SortKey       KeyTemplate
+	Indexes       []EntityIndex
+}
+
+// EntityIndex is an overloaded global secondary index whose key attributes are composed from templates.
+// SortKeyAttribute and SortKey are left empty for indexes without a sort key.
+type EntityIndex struct {
+	Name                  string
+	PartitionKeyAttribute string
+	PartitionKey          KeyTemplate
+	SortKeyAttribute      string
+	SortKey               KeyTemplate
+}
+
+// EntityRepository is a typed repository for an entity T sharing its table with other entities.
+// Table and index keys are composed from the attributes of T named by the templates on writes, and those
+// attributes are parsed back from the table key on reads when the stored item lacks them, as under a projection.
+// The API Gateway request used for logging is taken from the context, see tracking.WithRequest.
+type EntityRepository[T any] struct {
+	core       *DynamoDBRepository
+	definition EntityDefinition
+	numeric    map[string]bool
+}
+
+// NewEntityRepository creates an EntityRepository for T, checking the templates of definition against
+// the key schema of core and the attributes of T.
+func NewEntityRepository[T any](core *DynamoDBRepository, definition EntityDefinition) (*EntityRepository[T], error) {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	if itemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("entity type %s must be a struct", itemType)
+	}
+	if len(definition.Name) == 0 {
+		return nil, errors.New("entity name is required")
+	}
+	if len(core.partitionKeyName) == 0 {
+		return nil, errors.New("key schema is required for entities")
+	}
+	if len(definition.TypeAttribute) == 0 {
+		definition.TypeAttribute = DefaultEntityTypeAttribute
+	}
+
+	templates := []KeyTemplate{definition.PartitionKey}
+	if len(core.sortKeyName) != 0 || len(definition.SortKey) != 0 {
+		if len(core.sortKeyName) == 0 {
+			return nil, fmt.Errorf("entity %s has a sort key template but the table has no sort key", definition.Name)
+		}
+		templates = append(templates, definition.SortKey)
+	}
+	for _, index := range definition.Indexes {
+		if len(index.Name) == 0 || len(index.PartitionKeyAttribute) == 0 {
+			return nil, fmt.Errorf("entity %s has an index without name or partition key attribute", definition.Name)
+		}
+		if (len(index.SortKeyAttribute) == 0) != (len(index.SortKey) == 0) {
+			return nil, fmt.Errorf("entity %s index %s needs both a sort key attribute and template", definition.Name, index.Name)
+		}
+		templates = append(templates, index.PartitionKey)
+		if len(index.SortKey) != 0 {
+			templates = append(templates, index.SortKey)
+		}
+	}
+
+	fieldTypes := make(map[string]reflect.Type)
+	for _, field := range attributeFields(itemType, nil) {
+		fieldTypes[field.name] = itemType.FieldByIndex(field.index).Type
+	}
+	repository := &EntityRepository[T]{core: core, definition: definition, numeric: make(map[string]bool)}
+	for _, template := range templates {
+		if err := template.Validate(); err != nil {
+			return nil, fmt.Errorf("entity %s: %w", definition.Name, err)
+		}
+		for _, attribute := range template.Attributes() {
+			fieldType, found := fieldTypes[attribute]
+			if !found {
+				return nil, fmt.Errorf("entity %s: key template %q uses attribute %s missing from %s", definition.Name, template, attribute, itemType)
+			}
+			repository.numeric[attribute] = isNumericType(fieldType)
+		}
+	}
+	return repository, nil
+}
+
+// Definition returns the definition of the entity.
+func (r *EntityRepository[T]) Definition() EntityDefinition {
+	return r.definition
+}
+
+// KeyFor composes the table key of the entity from the values of its template attributes.
+func (r *EntityRepository[T]) KeyFor(values map[string]string) (Key, error) {
+	partitionKey, err := r.definition.PartitionKey.Compose(values)
+	if err != nil {
+		return Key{}, err
+	}
+	key := NewStringKey(r.core.partitionKeyName, partitionKey)
+	if len(r.definition.SortKey) != 0 {
+		sortKey, errorSortKey := r.definition.SortKey.Compose(values)
+		if errorSortKey != nil {
+			return Key{}, errorSortKey
+		}
+		key = key.WithSortKey(r.core.sortKeyName, StringValue(sortKey))
+	}
+	return key, nil
+}
+
+// KeyOf composes the table key of an item.
+func (r *EntityRepository[T]) KeyOf(item T) (Key, error) {
+	attributes, err := helpers.MarshallItem(item)
+	if err != nil {
+		return Key{}, err
+	}
+	return r.KeyFor(templateValues(attributes))
+}
+
+// Marshal converts an item into the attributes stored in the table: its own attributes, the table and
+// index keys composed from them and the entity type. It is used by Put and can feed a TransactionBuilder.
+func (r *EntityRepository[T]) Marshal(item T) (map[string]types.AttributeValue, error) {
+	attributes, err := helpers.MarshallItem(item)
+	if err != nil {
+		return nil, err
+	}
+	values := templateValues(attributes)
+	key, err := r.KeyFor(values)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range key.AttributeMap() {
+		attributes[name] = value
+	}
+	for _, index := range r.definition.Indexes {
+		// Items lacking the attributes of an index key are left out of that sparse index.
+		partitionKey, errorPartitionKey := index.PartitionKey.Compose(values)
+		if errorPartitionKey != nil {
+			continue
+		}
+		var sortKey string
+		if len(index.SortKey) != 0 {
+			var errorSortKey error
+			if sortKey, errorSortKey = index.SortKey.Compose(values); errorSortKey != nil {
+				continue
+			}
+			attributes[index.SortKeyAttribute] = StringValue(sortKey)
+		}
+		attributes[index.PartitionKeyAttribute] = StringValue(partitionKey)
+	}
+	attributes[r.definition.TypeAttribute] = StringValue(r.definition.Name)
+	return attributes, nil
+}
+
+// Unmarshal converts stored attributes into an item, filling the template attributes missing from them
+// with the values parsed from the table key.
+func (r *EntityRepository[T]) Unmarshal(attributes map[string]types.AttributeValue) (T, error) {
+	var item T
+	templates := map[string]KeyTemplate{r.core.partitionKeyName: r.definition.PartitionKey}
+	if len(r.definition.SortKey) != 0 {
+		templates[r.core.sortKeyName] = r.definition.SortKey
+	}
+	completed := make(map[string]types.AttributeValue, len(attributes))
+	for name, value := range attributes {
+		completed[name] = value
+	}
+	for keyName, template := range templates {
+		stored, ok := attributes[keyName].(*types.AttributeValueMemberS)
+		if !ok {
+			continue
+		}
+		values, err := template.Parse(stored.Value)
+		if err != nil {
+			return item, err
+		}
+		for attribute, value := range values {
+			if _, found := completed[attribute]; found {
+				continue
+			}
+			if r.numeric[attribute] {
+				completed[attribute] = NumberValue(value)
+			} else {
+				completed[attribute] = StringValue(value)
+			}
+		}
+	}
+	err := helpers.UnmarshalMapToType(completed, &item)
+	return item, err
+}
+
+// Is reports whether stored attributes belong to the entity, for example to route stream records.
+func (r *EntityRepository[T]) Is(attributes map[string]types.AttributeValue) bool {
+	entityType, ok := attributes[r.definition.TypeAttribute].(*types.AttributeValueMemberS)
+	return ok && entityType.Value == r.definition.Name
+}
+
+// Create stores a new item, failing with ErrAlreadyExists when its key is taken.
+func (r *EntityRepository[T]) Create(ctx context.Context, item T, opts ...PutOption) error {
+	attributes, err := r.Marshal(item)
+	if err != nil {
+		return newOperationError("Create", ErrValidation, err)
+	}
+	opts = append(append([]PutOption{}, opts...), WithPutMode(PutModeCreate))
+	return r.core.PutItemCore(ctx, tracking.RequestFromContext(ctx), attributes, opts...)
+}
+
+// Put stores an item, replacing any existing item with the same key.
+func (r *EntityRepository[T]) Put(ctx context.Context, item T, opts ...PutOption) error {
+	attributes, err := r.Marshal(item)
+	if err != nil {
+		return newOperationError("Put", ErrValidation, err)
+	}
+	return r.core.PutItemCore(ctx, tracking.RequestFromContext(ctx), attributes, opts...)
+}
+
+// Get reads the item stored at key, failing with ErrNotFound when there is none or it belongs to another entity.
+func (r *EntityRepository[T]) Get(ctx context.Context, key Key, opts ...ReadOption) (T, error) {
+	var item T
+	if projection := newReadOptions(opts).projection; len(projection) != 0 {
+		opts = append(append([]ReadOption{}, opts...), WithProjection(r.missingKeyAttributes(projection)...))
+	}
+	response, err := r.core.GetItemCore(ctx, tracking.RequestFromContext(ctx), key, opts...)
+	if err != nil {
+		return item, err
+	}
+	if !r.Is(response.Item) {
+		return item, newOperationError("Get", ErrNotFound, fmt.Errorf("item is not a %s", r.definition.Name))
+	}
+	item, err = r.Unmarshal(response.Item)
+	if err != nil {
+		return item, newOperationError("Get", ErrValidation, err)
+	}
+	return item, nil
+}
+
+// Update writes the attributes of an existing item, see UpdateItemCore for the available options.
+// Index keys are only composed by Put and Create, so use them when an attribute of an index template changes.
+func (r *EntityRepository[T]) Update(ctx context.Context, item T, skipFields []string, opts ...UpdateOption) error {
+	key, err := r.KeyOf(item)
+	if err != nil {
+		return wrapError("Update", err)
+	}
+	return r.core.UpdateItemCore(ctx, tracking.RequestFromContext(ctx), item, key, skipFields, opts...)
+}
+
+// Delete removes the item stored at key, see DeleteItemCore for the available options.
+func (r *EntityRepository[T]) Delete(ctx context.Context, key Key, opts ...DeleteOption) error {
+	return r.core.DeleteItemCore(ctx, tracking.RequestFromContext(ctx), key, opts...)
+}
+
+// Query starts a query of the items of the entity in the table, or in one of its indexes when indexName is set.
+// The partition key is composed from values, the sort key is matched with begins_with on the part of its
+// template that values can compose, and the items of other entities are filtered out.
+// The query already has a sort key condition when the sort key template starts with literal text.
+func (r *EntityRepository[T]) Query(indexName string, values map[string]string) *QueryBuilder {
+	partitionKeyName, partitionKeyTemplate := r.core.partitionKeyName, r.definition.PartitionKey
+	sortKeyName, sortKeyTemplate := r.core.sortKeyName, r.definition.SortKey
+	if len(indexName) != 0 {
+		index, found := r.index(indexName)
+		if !found {
+			query := NewQuery(partitionKeyName, "")
+			query.err = fmt.Errorf("entity %s has no index %s", r.definition.Name, indexName)
+			return query
+		}
+		partitionKeyName, partitionKeyTemplate = index.PartitionKeyAttribute, index.PartitionKey
+		sortKeyName, sortKeyTemplate = index.SortKeyAttribute, index.SortKey
+	}
+
+	partitionKey, err := partitionKeyTemplate.Compose(values)
+	query := NewQuery(partitionKeyName, partitionKey).Index(indexName)
+	if err != nil {
+		query.err = err
+		return query
+	}
+	if len(sortKeyTemplate) != 0 {
+		prefix, errorPrefix := sortKeyTemplate.Prefix(values)
+		if errorPrefix != nil {
+			query.err = errorPrefix
+			return query
+		}
+		if len(prefix) != 0 {
+			query.SortKeyBeginsWith(sortKeyName, prefix)
+		}
+	}
+	return query.FilterEquals(r.definition.TypeAttribute, r.definition.Name)
+}
+
+// Find reads one page of the items matching query, returning the cursor of the next page.
+// A projection of query is extended with the key and type attributes without changing query.
+func (r *EntityRepository[T]) Find(ctx context.Context, query *QueryBuilder, cursor string) ([]T, string, error) {
+	if len(query.projection) != 0 {
+		projected := *query
+		projected.projection = append(append([]string{}, query.projection...), r.missingKeyAttributes(query.projection)...)
+		query = &projected
+	}
+	page, err := r.core.QueryCore(ctx, tracking.RequestFromContext(ctx), query, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]T, 0, len(page.Items))
+	for _, attributes := range page.Items {
+		if !r.Is(attributes) {
+			continue
+		}
+		item, errorUnmarshal := r.Unmarshal(attributes)
+		if errorUnmarshal != nil {
+			return nil, "", newOperationError("Find", ErrValidation, errorUnmarshal)
+		}
+		items = append(items, item)
+	}
+	return items, page.NextCursor, nil
+}
+
+// index returns the index of the entity with the given name.
+func (r *EntityRepository[T]) index(name string) (EntityIndex, bool) {
+	for _, index := range r.definition.Indexes {
+		if index.Name == name {
+			return index, true
+		}
+	}
+	return EntityIndex{}, false
+}
+
+// missingKeyAttributes returns the attributes a projection lacks to recognize and unmarshal items of the entity.
+func (r *EntityRepository[T]) missingKeyAttributes(projection []string) []string {
+	names := []string{r.definition.TypeAttribute, r.core.partitionKeyName}
+	if len(r.definition.SortKey) != 0 {
+		names = append(names, r.core.sortKeyName)
+	}
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, projected := range projection {
+			found = found || projected == name
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// templateValues returns the string and number attributes of an item, as used by key templates.
+func templateValues(attributes map[string]types.AttributeValue) map[string]string {
+	values := make(map[string]string, len(attributes))
+	for name, attribute := range attributes {
+		switch value := attribute.(type) {
+		case *types.AttributeValueMemberS:
+			values[name] = value.Value
+		case *types.AttributeValueMemberN:
+			values[name] = value.Value
+		}
+	}
+	return values
+}
+
+// isNumericType reports whether attributevalue stores values of a type as numbers.
+func isNumericType(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package dynamodbcore_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type customer struct {
+	MerchantID string `dynamodbav:"merchantID"`
+	CustomerID string `dynamodbav:"customerID"`
+	Name       string `dynamodbav:"name"`
+}
+
+func TestKeyTemplateCompose(t *testing.T) {
+	template := dynamodbcore.KeyTemplate("MERCHANT#{merchantID}#CUSTOMER#{customerID}")
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    string
+		wantErr error
+	}{
+		{name: "every value", values: map[string]string{"merchantID": "m1", "customerID": "c1"}, want: "MERCHANT#m1#CUSTOMER#c1"},
+		{name: "missing value", values: map[string]string{"merchantID": "m1"}, wantErr: dynamodbcore.ErrInvalidKey},
+		{name: "value containing the next separator", values: map[string]string{"merchantID": "m1#CUSTOMER#c2", "customerID": "c1"}, wantErr: dynamodbcore.ErrInvalidKey},
+		{name: "last value containing a separator", values: map[string]string{"merchantID": "m1", "customerID": "c1#x"}, want: "MERCHANT#m1#CUSTOMER#c1#x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := template.Compose(test.values)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("Compose error = %v, want %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("Compose = %q, want %q", got, test.want)
+			}
+			if err != nil {
+				return
+			}
+			if values, errorParse := template.Parse(got); errorParse != nil || !reflect.DeepEqual(values, test.values) {
+				t.Errorf("Parse(%q) = %v, %v, want %v", got, values, errorParse, test.values)
+			}
+		})
+	}
+}
+
+func TestEntityFindKeepsQuery(t *testing.T) {
+	client := dynamodbtest.NewClient(dynamodbtest.TableDefinition{Name: "single", PartitionKey: "pk", SortKey: "sk"})
+	core, err := dynamodbcore.NewDynamoDBRepository("single", "us-east-1",
+		dynamodbcore.WithClient(client),
+		dynamodbcore.WithCursorSecret([]byte("test-secret")),
+		dynamodbcore.WithKeySchema("pk", "sk"),
+	)
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository error = %v", err)
+	}
+	customers, err := dynamodbcore.NewEntityRepository[customer](core, dynamodbcore.EntityDefinition{
+		Name:         "Customer",
+		PartitionKey: "MERCHANT#{merchantID}",
+		SortKey:      "CUSTOMER#{customerID}",
+	})
+	if err != nil {
+		t.Fatalf("NewEntityRepository error = %v", err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"c1", "c2"} {
+		if err := customers.Create(ctx, customer{MerchantID: "m1", CustomerID: id, Name: "Customer " + id}); err != nil {
+			t.Fatalf("Create error = %v", err)
+		}
+	}
+
+	query := customers.Query("", map[string]string{"merchantID": "m1"}).Project("name")
+	found, _, err := customers.Find(ctx, query, "")
+	if err != nil {
+		t.Fatalf("Find error = %v", err)
+	}
+	want := []customer{{MerchantID: "m1", CustomerID: "c1", Name: "Customer c1"}, {MerchantID: "m1", CustomerID: "c2", Name: "Customer c2"}}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Find = %+v, want %+v", found, want)
+	}
+
+	// The query of the caller still projects only the name.
+	page, err := core.QueryCore(ctx, events.APIGatewayProxyRequest{}, query, "")
+	if err != nil {
+		t.Fatalf("QueryCore error = %v", err)
+	}
+	for _, item := range page.Items {
+		names := make([]string, 0, len(item))
+		for name := range item {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, []string{"name"}) {
+			t.Errorf("QueryCore item attributes = %v, want [name]", names)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/smithy-go"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when the requested item does not exist.
+	ErrNotFound = errors.New("item not found")
+	// ErrAlreadyExists is returned when a create-only put finds an item at the same key.
+	ErrAlreadyExists = errors.New("item already exists")
+	// ErrConditionFailed is returned when a condition expression evaluates to false.
+	ErrConditionFailed = errors.New("condition check failed")
+	// ErrThrottled is returned when DynamoDB rejects the request because of throughput limits.
+	ErrThrottled = errors.New("request throttled")
+	// ErrValidation is returned when the request is rejected as invalid.
+	ErrValidation = errors.New("validation error")
+	// ErrTransactionConflict is returned when another transaction is modifying the same item.
+	ErrTransactionConflict = errors.New("transaction conflict")
+	// ErrUnprocessedItems is returned when a batch operation could not finish before its deadline.
+	ErrUnprocessedItems = errors.New("unprocessed batch items")
+	// ErrTableNotFound is returned when the table or index does not exist.
+	ErrTableNotFound = errors.New("table not found")
+	// ErrVersionConflict is returned when the stored version of an item differs from the expected one.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrInvalidKey is returned when a key has no partition key or uses an unsupported attribute type.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrInvalidCursor is returned when a pagination cursor is malformed or its signature does not match.
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+	// ErrCircuitOpen is returned without calling DynamoDB while the circuit breaker of a table is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrTimeout is returned when an operation has no time left before its deadline or exceeds its budget.
+	ErrTimeout = errors.New("operation timed out")
+)
+
+// OperationError describes a failed repository operation.
+// Kind holds one of the sentinel errors of this package and Err the underlying cause.
+type OperationError struct {
+	Operation string
+	Kind      error
+	Err       error
+}
+
+// Error returns the string representation of the error.
+func (e *OperationError) Error() string {
+	message := e.Operation
+	if e.Kind != nil {
+		message += ": " + e.Kind.Error()
+	}
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+// Is reports whether the error is of the given sentinel kind.
+func (e *OperationError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Unwrap returns the underlying cause so it can be inspected with errors.As.
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// newOperationError builds an OperationError of a known kind.
+func newOperationError(operation string, kind error, err error) error {
+	return &OperationError{
+		Operation: operation,
+		Kind:      kind,
+		Err:       err,
+	}
+}
+
+// wrapError classifies an error returned by the client and wraps it in an OperationError.
+func wrapError(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var operationError *OperationError
+	if errors.As(err, &operationError) {
+		return err
+	}
+	return newOperationError(operation, classifyError(err), err)
+}
+
+// classifyError maps an error to one of the sentinel errors of this package.
+func classifyError(err error) error {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return ErrCircuitOpen
+	}
+	if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrInvalidCursor) {
+		return ErrValidation
+	}
+
+	var apiError smithy.APIError
+	if !errors.As(err, &apiError) {
+		return nil
+	}
+	switch apiError.ErrorCode() {
+	case "ConditionalCheckFailedException":
+		return ErrConditionFailed
+	case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded":
+		return ErrThrottled
+	case "ValidationException":
+		return ErrValidation
+	case "TransactionConflictException":
+		return ErrTransactionConflict
+	case "ResourceNotFoundException":
+		return ErrTableNotFound
+	}
+	return nil
+}
+
+// isInvalidDocumentPath reports whether an update failed because a nested path has no parent map to be set in,
+// whether it was made alone or in a transaction.
+func isInvalidDocumentPath(err error) bool {
+	const invalidDocumentPath = "document path provided in the update expression is invalid"
+	var transactionError *TransactionCanceledError
+	if errors.As(err, &transactionError) {
+		for _, reason := range transactionError.Reasons {
+			if reason.Code == "ValidationError" && strings.Contains(reason.Message, invalidDocumentPath) {
+				return true
+			}
+		}
+	}
+	var apiError smithy.APIError
+	return errors.As(err, &apiError) && apiError.ErrorCode() == "ValidationException" &&
+		strings.Contains(apiError.ErrorMessage(), invalidDocumentPath)
+}
@@ -0,0 +1,400 @@
+package dynamodbcore
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"github.com/diegocabrera89/ms-payment-core/streams"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxHistoryAttempts bounds how many times a write recorded in the history is tried again when the item
+// changes between the read of its previous values and the write.
+const maxHistoryAttempts = 3
+
+// historyChange describes a write for its history record.
+type historyChange struct {
+	operation string
+	key       Key
+	// item is the whole item written by a put.
+	item map[string]types.AttributeValue
+	// updates lists the attributes written by an update or a soft delete. A hard delete has neither.
+	updates []historyUpdate
+}
+
+// historyUpdate is the write of one attribute, by name or nested path, by an update.
+type historyUpdate struct {
+	path      string
+	operation helpers.UpdateOperation
+	// value is the operand of the operation, nil for removals.
+	value types.AttributeValue
+}
+
+// after returns the item as the change leaves the item before.
+func (c historyChange) after(before map[string]types.AttributeValue) map[string]types.AttributeValue {
+	switch {
+	case c.item != nil:
+		return c.item
+	case c.updates != nil:
+		after := copyItem(before)
+		if after == nil {
+			after = make(map[string]types.AttributeValue)
+		}
+		for _, update := range c.updates {
+			applyUpdate(after, update)
+		}
+		return after
+	}
+	return nil
+}
+
+// changedAttributes returns the sorted attribute names, or nested paths for updates, whose value differs
+// between before and after.
+func (c historyChange) changedAttributes(before map[string]types.AttributeValue, after map[string]types.AttributeValue) []string {
+	if c.updates == nil {
+		return streams.ChangedAttributes(before, after)
+	}
+	seen := make(map[string]bool, len(c.updates))
+	var changed []string
+	for _, update := range c.updates {
+		if seen[update.path] {
+			continue
+		}
+		seen[update.path] = true
+		beforeValue, inBefore := c.valueAt(before, update.path)
+		afterValue, inAfter := c.valueAt(after, update.path)
+		if inBefore != inAfter || (inBefore && !streams.EqualAttributeValues(beforeValue, afterValue)) {
+			changed = append(changed, update.path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// valueAt returns the value of a changed attribute of item.
+func (c historyChange) valueAt(item map[string]types.AttributeValue, name string) (types.AttributeValue, bool) {
+	if c.updates == nil {
+		value, found := item[name]
+		return value, found
+	}
+	return valueAtPath(item, parsePath(name))
+}
+
+// historyUpdates lists the attribute writes of an update for its history record.
+func historyUpdates(updateValues map[string]interface{}, removeFields []string, skipFields []string, operations map[string]helpers.UpdateOperation) ([]historyUpdate, error) {
+	values, err := historyValues(updateValues, skipFields)
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]historyUpdate, 0, len(values)+len(removeFields))
+	for fieldName, value := range values {
+		updates = append(updates, historyUpdate{path: fieldName, operation: operations[fieldName], value: value})
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].path < updates[j].path })
+	for _, fieldName := range removeFields {
+		if !helpers.SkipUpdatingFields(fieldName, skipFields) {
+			updates = append(updates, historyUpdate{path: fieldName})
+		}
+	}
+	return updates, nil
+}
+
+// itemBefore reads the item stored at key, as it is before a change.
+func (d DynamoDBRepository) itemBefore(ctx context.Context, key Key) (map[string]types.AttributeValue, error) {
+	response, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            key.AttributeMap(),
+		TableName:      aws.String(d.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Item, nil
+}
+
+// unchangedCondition holds while the item is still as read in before: it has the same version when versioning
+// is enabled and the same attributes otherwise, or it still does not exist.
+func (d DynamoDBRepository) unchangedCondition(before map[string]types.AttributeValue) expression.ConditionBuilder {
+	if len(before) == 0 {
+		return expression.AttributeNotExists(expression.NameNoDotSplit(d.partitionKeyName))
+	}
+	if version, found := before[d.versionAttribute]; found && len(d.versionAttribute) != 0 {
+		return expression.NameNoDotSplit(d.versionAttribute).Equal(expression.Value(version))
+	}
+	names := make([]string, 0, len(before))
+	for name := range before {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	conditions := make([]expression.ConditionBuilder, 0, len(names))
+	for _, name := range names {
+		conditions = append(conditions, expression.NameNoDotSplit(name).Equal(expression.Value(before[name])))
+	}
+	condition, _ := joinConditions(conditions)
+	return condition
+}
+
+// expressionPlaceholder matches the placeholders of the expressions built by the expression package.
+var expressionPlaceholder = regexp.MustCompile(`[#:][0-9]+`)
+
+// withCondition adds condition to the condition of a put, update or delete, renaming its placeholders so
+// they do not clash with those of the write, and asks for the stored item when the condition fails.
+func withCondition(write types.TransactWriteItem, condition expression.ConditionBuilder) (types.TransactWriteItem, error) {
+	expr, err := expression.NewBuilder().WithCondition(condition).Build()
+	if err != nil {
+		return write, err
+	}
+	rename := func(placeholder string) string {
+		return placeholder[:1] + "unchanged" + placeholder[1:]
+	}
+
+	var conditionExpression **string
+	var names *map[string]string
+	var values *map[string]types.AttributeValue
+	var returnValues *types.ReturnValuesOnConditionCheckFailure
+	switch {
+	case write.Put != nil:
+		put := *write.Put
+		write.Put = &put
+		conditionExpression, names, values, returnValues = &put.ConditionExpression, &put.ExpressionAttributeNames, &put.ExpressionAttributeValues, &put.ReturnValuesOnConditionCheckFailure
+	case write.Update != nil:
+		update := *write.Update
+		write.Update = &update
+		conditionExpression, names, values, returnValues = &update.ConditionExpression, &update.ExpressionAttributeNames, &update.ExpressionAttributeValues, &update.ReturnValuesOnConditionCheckFailure
+	case write.Delete != nil:
+		deleteItem := *write.Delete
+		write.Delete = &deleteItem
+		conditionExpression, names, values, returnValues = &deleteItem.ConditionExpression, &deleteItem.ExpressionAttributeNames, &deleteItem.ExpressionAttributeValues, &deleteItem.ReturnValuesOnConditionCheckFailure
+	default:
+		return write, nil
+	}
+
+	added := expressionPlaceholder.ReplaceAllStringFunc(aws.ToString(expr.Condition()), rename)
+	if existing := aws.ToString(*conditionExpression); len(existing) != 0 {
+		added = "(" + existing + ") AND (" + added + ")"
+	}
+	*conditionExpression = aws.String(added)
+	mergedNames := make(map[string]string, len(*names)+len(expr.Names()))
+	for placeholder, name := range *names {
+		mergedNames[placeholder] = name
+	}
+	for placeholder, name := range expr.Names() {
+		mergedNames[rename(placeholder)] = name
+	}
+	*names = mergedNames
+	mergedValues := make(map[string]types.AttributeValue, len(*values)+len(expr.Values()))
+	for placeholder, value := range *values {
+		mergedValues[placeholder] = value
+	}
+	for placeholder, value := range expr.Values() {
+		mergedValues[rename(placeholder)] = value
+	}
+	if len(mergedValues) != 0 {
+		// DynamoDB rejects empty expression attribute values.
+		*values = mergedValues
+	}
+	*returnValues = types.ReturnValuesOnConditionCheckFailureAllOld
+	return write, nil
+}
+
+// pathElement is an attribute name or a list index of a document path.
+type pathElement struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// parsePath splits a document path such as address.lines[0] into its elements.
+func parsePath(path string) []pathElement {
+	var elements []pathElement
+	for _, segment := range strings.Split(path, ".") {
+		name, indexes, _ := strings.Cut(segment, "[")
+		elements = append(elements, pathElement{name: name})
+		if len(indexes) == 0 {
+			continue
+		}
+		for _, index := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			position, err := strconv.Atoi(index)
+			if err != nil {
+				position = -1
+			}
+			elements = append(elements, pathElement{index: position, isIndex: true})
+		}
+	}
+	return elements
+}
+
+// valueAtPath returns the value found at a document path of item.
+func valueAtPath(item map[string]types.AttributeValue, elements []pathElement) (types.AttributeValue, bool) {
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, element := range elements {
+		var found bool
+		if current, found = childOf(current, element); !found {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// childOf returns the attribute of a map or the element of a list designated by element.
+func childOf(parent types.AttributeValue, element pathElement) (types.AttributeValue, bool) {
+	switch container := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if element.isIndex {
+			return nil, false
+		}
+		value, found := container.Value[element.name]
+		return value, found
+	case *types.AttributeValueMemberL:
+		if !element.isIndex || element.index < 0 || element.index >= len(container.Value) {
+			return nil, false
+		}
+		return container.Value[element.index], true
+	}
+	return nil, false
+}
+
+// applyUpdate writes update on item the way DynamoDB does. Paths that cannot be resolved are left alone,
+// since DynamoDB rejects the update writing them.
+func applyUpdate(item map[string]types.AttributeValue, update historyUpdate) {
+	elements := parsePath(update.path)
+	last := elements[len(elements)-1]
+	var parent types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, element := range elements[:len(elements)-1] {
+		child, found := childOf(parent, element)
+		if !found && !element.isIndex && update.value != nil {
+			if container, isMap := parent.(*types.AttributeValueMemberM); isMap {
+				child = &types.AttributeValueMemberM{Value: make(map[string]types.AttributeValue)}
+				container.Value[element.name] = child
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+		parent = child
+	}
+
+	current, found := childOf(parent, last)
+	value := update.value
+	switch {
+	case value == nil:
+		removeElement(parent, last)
+		return
+	case update.operation == helpers.UpdateOperationSetIfNotExists && found:
+		return
+	case update.operation == helpers.UpdateOperationAdd && found:
+		value = addValues(current, value)
+	case update.operation == helpers.UpdateOperationAppend && found:
+		if list, isList := current.(*types.AttributeValueMemberL); isList {
+			if operand, operandIsList := value.(*types.AttributeValueMemberL); operandIsList {
+				value = &types.AttributeValueMemberL{Value: append(append([]types.AttributeValue{}, list.Value...), operand.Value...)}
+			}
+		}
+	}
+	switch container := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if !last.isIndex {
+			container.Value[last.name] = value
+		}
+	case *types.AttributeValueMemberL:
+		switch {
+		case !last.isIndex || last.index < 0:
+		case last.index < len(container.Value):
+			container.Value[last.index] = value
+		default:
+			container.Value = append(container.Value, value)
+		}
+	}
+}
+
+// removeElement removes an attribute from a map or an element from a list.
+func removeElement(parent types.AttributeValue, element pathElement) {
+	switch container := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if !element.isIndex {
+			delete(container.Value, element.name)
+		}
+	case *types.AttributeValueMemberL:
+		if element.isIndex && element.index >= 0 && element.index < len(container.Value) {
+			container.Value = append(container.Value[:element.index:element.index], container.Value[element.index+1:]...)
+		}
+	}
+}
+
+// addValues returns the result of ADD: the sum of two numbers or the union of two sets of the same type.
+func addValues(current types.AttributeValue, operand types.AttributeValue) types.AttributeValue {
+	switch c := current.(type) {
+	case *types.AttributeValueMemberN:
+		if o, ok := operand.(*types.AttributeValueMemberN); ok {
+			return &types.AttributeValueMemberN{Value: addNumbers(c.Value, o.Value)}
+		}
+	case *types.AttributeValueMemberSS:
+		if o, ok := operand.(*types.AttributeValueMemberSS); ok {
+			return &types.AttributeValueMemberSS{Value: unionStrings(c.Value, o.Value)}
+		}
+	case *types.AttributeValueMemberNS:
+		if o, ok := operand.(*types.AttributeValueMemberNS); ok {
+			return &types.AttributeValueMemberNS{Value: unionStrings(c.Value, o.Value)}
+		}
+	case *types.AttributeValueMemberBS:
+		if o, ok := operand.(*types.AttributeValueMemberBS); ok {
+			union := append([][]byte{}, c.Value...)
+			for _, member := range o.Value {
+				found := false
+				for _, existing := range c.Value {
+					found = found || string(existing) == string(member)
+				}
+				if !found {
+					union = append(union, member)
+				}
+			}
+			return &types.AttributeValueMemberBS{Value: union}
+		}
+	}
+	return current
+}
+
+// unionStrings returns the members of left followed by those of right missing from left.
+func unionStrings(left []string, right []string) []string {
+	union := append([]string{}, left...)
+	for _, member := range right {
+		found := false
+		for _, existing := range left {
+			found = found || existing == member
+		}
+		if !found {
+			union = append(union, member)
+		}
+	}
+	return union
+}
+
+// addNumbers returns the exact sum of two numbers written as DynamoDB does.
+func addNumbers(left string, right string) string {
+	leftValue, leftOk := new(big.Rat).SetString(left)
+	rightValue, rightOk := new(big.Rat).SetString(right)
+	if !leftOk || !rightOk {
+		return left
+	}
+	sum := new(big.Rat).Add(leftValue, rightValue)
+	if sum.IsInt() {
+		return sum.Num().String()
+	}
+	// DynamoDB numbers are decimals of at most 38 digits, so some scale of at most 38 is exact.
+	scaled := new(big.Rat).Set(sum)
+	ten := big.NewRat(10, 1)
+	for digits := 1; digits < 38; digits++ {
+		if scaled.Mul(scaled, ten).IsInt() {
+			return sum.FloatString(digits)
+		}
+	}
+	return sum.FloatString(38)
+}
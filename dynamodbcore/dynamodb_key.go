@@ -0,0 +1,111 @@
+package dynamodbcore
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+)
+
+// KeyAttribute represents a single key attribute and its value.
+type KeyAttribute struct {
+	Name  string
+	Value types.AttributeValue
+}
+
+// Key represents the primary key of an item, made of a partition key and an optional sort key.
+type Key struct {
+	PartitionKey KeyAttribute
+	SortKey      *KeyAttribute
+}
+
+// NewKey creates a key with only a partition key.
+func NewKey(partitionKeyName string, partitionKeyValue types.AttributeValue) Key {
+	return Key{
+		PartitionKey: KeyAttribute{Name: partitionKeyName, Value: partitionKeyValue},
+	}
+}
+
+// NewStringKey creates a key with a single string partition key.
+func NewStringKey(partitionKeyName string, partitionKeyValue string) Key {
+	return NewKey(partitionKeyName, StringValue(partitionKeyValue))
+}
+
+// WithSortKey returns a copy of the key including the given sort key.
+func (k Key) WithSortKey(sortKeyName string, sortKeyValue types.AttributeValue) Key {
+	k.SortKey = &KeyAttribute{Name: sortKeyName, Value: sortKeyValue}
+	return k
+}
+
+// StringValue builds a string key value.
+func StringValue(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+// NumberValue builds a number key value from its decimal representation.
+func NumberValue(value string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: value}
+}
+
+// IntValue builds a number key value from an integer.
+func IntValue(value int64) types.AttributeValue {
+	return NumberValue(strconv.FormatInt(value, 10))
+}
+
+// BinaryValue builds a binary key value.
+func BinaryValue(value []byte) types.AttributeValue {
+	return &types.AttributeValueMemberB{Value: value}
+}
+
+// Validate checks that the key has a partition key and that every value is a string, number or binary.
+func (k Key) Validate() error {
+	if len(k.PartitionKey.Name) == 0 {
+		return fmt.Errorf("%w: partition key name is required", ErrInvalidKey)
+	}
+	for _, attribute := range k.attributes() {
+		if len(attribute.Name) == 0 {
+			return fmt.Errorf("%w: key attribute name is required", ErrInvalidKey)
+		}
+		switch attribute.Value.(type) {
+		case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		default:
+			return fmt.Errorf("%w: unsupported type %T for %s", ErrInvalidKey, attribute.Value, attribute.Name)
+		}
+	}
+	return nil
+}
+
+// Names returns the attribute names that make up the key.
+func (k Key) Names() []string {
+	names := make([]string, 0, 2)
+	for _, attribute := range k.attributes() {
+		names = append(names, attribute.Name)
+	}
+	return names
+}
+
+// AttributeMap converts the key into the format expected by DynamoDB.
+func (k Key) AttributeMap() map[string]types.AttributeValue {
+	key := make(map[string]types.AttributeValue, 2)
+	for _, attribute := range k.attributes() {
+		key[attribute.Name] = attribute.Value
+	}
+	return key
+}
+
+// existsCondition builds a condition requiring every key attribute to exist.
+func (k Key) existsCondition() expression.ConditionBuilder {
+	condition := expression.AttributeExists(expression.Name(k.PartitionKey.Name))
+	if k.SortKey != nil {
+		condition = condition.And(expression.AttributeExists(expression.Name(k.SortKey.Name)))
+	}
+	return condition
+}
+
+// attributes returns the key attributes in partition, sort order.
+func (k Key) attributes() []KeyAttribute {
+	if k.SortKey == nil {
+		return []KeyAttribute{k.PartitionKey}
+	}
+	return []KeyAttribute{k.PartitionKey, *k.SortKey}
+}
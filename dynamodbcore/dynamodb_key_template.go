@@ -0,0 +1,149 @@
+package dynamodbcore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyTemplate is the pattern of a composite key in a single-table design, made of literal text and
+// placeholders naming the attributes it is built from, for example "MERCHANT#{merchantID}".
+// Two placeholders must be separated by literal text so that keys can be parsed back.
+type KeyTemplate string
+
+// templatePart is either a literal text or the attribute of a placeholder.
+type templatePart struct {
+	literal   string
+	attribute string
+}
+
+// parts splits the template into literal texts and placeholders.
+func (t KeyTemplate) parts() ([]templatePart, error) {
+	var parts []templatePart
+	rest := string(t)
+	for len(rest) != 0 {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q has an unclosed placeholder", t)
+		}
+		attribute := rest[start+1 : start+end]
+		if len(attribute) == 0 || strings.Contains(attribute, "{") {
+			return nil, fmt.Errorf("key template %q has an invalid placeholder", t)
+		}
+		if len(parts) != 0 && len(parts[len(parts)-1].attribute) != 0 {
+			return nil, fmt.Errorf("key template %q has adjacent placeholders", t)
+		}
+		parts = append(parts, templatePart{attribute: attribute})
+		rest = rest[start+end+1:]
+	}
+	return parts, nil
+}
+
+// Validate checks the syntax of the template.
+func (t KeyTemplate) Validate() error {
+	if len(t) == 0 {
+		return errors.New("key template is empty")
+	}
+	_, err := t.parts()
+	return err
+}
+
+// Attributes returns the attributes named by the placeholders, in template order.
+func (t KeyTemplate) Attributes() []string {
+	parts, _ := t.parts()
+	var attributes []string
+	for _, part := range parts {
+		if len(part.attribute) != 0 {
+			attributes = append(attributes, part.attribute)
+		}
+	}
+	return attributes
+}
+
+// Compose builds a key replacing every placeholder with the value of its attribute.
+// A value containing the literal text that follows its placeholder fails with ErrInvalidKey, since
+// the key could not be parsed back.
+func (t KeyTemplate) Compose(values map[string]string) (string, error) {
+	parts, err := t.parts()
+	if err != nil {
+		return "", err
+	}
+	var key strings.Builder
+	for i, part := range parts {
+		if len(part.attribute) == 0 {
+			key.WriteString(part.literal)
+			continue
+		}
+		value := values[part.attribute]
+		if len(value) == 0 {
+			return "", fmt.Errorf("%w: attribute %s is required by key template %q", ErrInvalidKey, part.attribute, t)
+		}
+		if i+1 < len(parts) && strings.Contains(value, parts[i+1].literal) {
+			return "", fmt.Errorf("%w: attribute %s contains %q, which separates it in key template %q", ErrInvalidKey, part.attribute, parts[i+1].literal, t)
+		}
+		key.WriteString(value)
+	}
+	return key.String(), nil
+}
+
+// Prefix builds the beginning of a key up to the first placeholder without a value,
+// to match every key sharing it with begins_with.
+func (t KeyTemplate) Prefix(values map[string]string) (string, error) {
+	parts, err := t.parts()
+	if err != nil {
+		return "", err
+	}
+	var prefix strings.Builder
+	for _, part := range parts {
+		if len(part.attribute) == 0 {
+			prefix.WriteString(part.literal)
+			continue
+		}
+		value := values[part.attribute]
+		if len(value) == 0 {
+			break
+		}
+		prefix.WriteString(value)
+	}
+	return prefix.String(), nil
+}
+
+// Parse extracts the value of every placeholder from a key built with the template.
+func (t KeyTemplate) Parse(key string) (map[string]string, error) {
+	parts, err := t.parts()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(parts))
+	rest := key
+	for i, part := range parts {
+		if len(part.attribute) == 0 {
+			if !strings.HasPrefix(rest, part.literal) {
+				return nil, fmt.Errorf("%w: %q does not match key template %q", ErrInvalidKey, key, t)
+			}
+			rest = rest[len(part.literal):]
+			continue
+		}
+		end := len(rest)
+		if i+1 < len(parts) {
+			end = strings.Index(rest, parts[i+1].literal)
+		}
+		if end <= 0 {
+			return nil, fmt.Errorf("%w: %q does not match key template %q", ErrInvalidKey, key, t)
+		}
+		values[part.attribute] = rest[:end]
+		rest = rest[end:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %q does not match key template %q", ErrInvalidKey, key, t)
+	}
+	return values, nil
+}
@@ -2,164 +2,581 @@ package dynamodbcore
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/constantscore"
 	"github.com/diegocabrera89/ms-payment-core/helpers"
 	"github.com/diegocabrera89/ms-payment-core/logs"
-	"log"
+	"os"
+	"strings"
 )
 
 // CoreRepository defines the interface for repository operations.
 type CoreRepository interface {
-	PutItemCore(ctx context.Context, request events.APIGatewayProxyRequest, item map[string]types.AttributeValue) error
-	GetItemCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string) (*dynamodb.GetItemOutput, error)
-	DeleteItemCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string) error
-	UpdateItemCore(ctx context.Context, request events.APIGatewayProxyRequest, itemObject interface{}, fieldNameFilterByID string, fieldValueFilterByID string, skipFields []string) error
+	PutItemCore(ctx context.Context, request events.APIGatewayProxyRequest, item map[string]types.AttributeValue, opts ...PutOption) error
+	GetItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...ReadOption) (*dynamodb.GetItemOutput, error)
+	DeleteItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...DeleteOption) error
+	UpdateItemCore(ctx context.Context, request events.APIGatewayProxyRequest, itemObject interface{}, key Key, skipFields []string, opts ...UpdateOption) error
 	GetItemByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, fieldNameFilterStatus string, fieldValueFilterStatus string) (*dynamodb.QueryOutput, error)
+	GetItemsByFieldPageCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, cursor string) (*QueryPage, error)
+	GetAllItemsByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, handlePage func(items []map[string]types.AttributeValue) error) error
+	QueryCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, cursor string) (*QueryPage, error)
+	QueryAllCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, handlePage func(items []map[string]types.AttributeValue) error) error
+	TransactWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder) error
+	TransactGetCore(ctx context.Context, request events.APIGatewayProxyRequest, items []TransactGetItem, opts ...ReadOption) ([]map[string]types.AttributeValue, error)
+	BatchGetCore(ctx context.Context, request events.APIGatewayProxyRequest, keys []Key, opts ...ReadOption) ([]map[string]types.AttributeValue, error)
+	BatchWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, puts []map[string]types.AttributeValue, deletes []Key) error
+	ScanCore(ctx context.Context, request events.APIGatewayProxyRequest, handleItems func(ctx context.Context, segment int32, items []map[string]types.AttributeValue) error, opts ...ScanOption) error
 }
 
 // DynamoDBRepository implements DynamoDBRepository for DynamoDB.
 type DynamoDBRepository struct {
-	client DynamoDBClientInterface
-	table  string
+	client           DynamoDBClientInterface
+	table            string
+	cursorSecret     []byte
+	versionAttribute string
+	timeoutPolicy    TimeoutPolicy
+	partitionKeyName string
+	sortKeyName      string
+	batchConcurrency int
+	batchRetryPolicy BatchRetryPolicy
+	clientOptions    clientOptions
+	softDelete       *SoftDeletePolicy
+	ttlAttribute     string
+	audit            *AuditPolicy
+	valuesOptions    []helpers.ValuesOption
+}
+
+// QueryPage represents one page of query results and the cursor to fetch the next one.
+type QueryPage struct {
+	Items      []map[string]types.AttributeValue
+	NextCursor string
 }
 
 // NewDynamoDBRepository createHandler a new DynamoDBRepository instance.
-func NewDynamoDBRepository(tableName string, region string) (*DynamoDBRepository, error) {
-	defaultConfig, err := config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
-		opts.Region = region
-		return nil
-	})
-	if err != nil {
-		log.Fatal("Error when load default config", err)
-		return nil, err
+// Unless a client is injected with WithClient, the AWS config is loaded for the given region.
+// Pagination cursors are signed with the secret of CURSOR_SECRET or WithCursorSecret, which is required.
+func NewDynamoDBRepository(tableName string, region string, opts ...Option) (*DynamoDBRepository, error) {
+	if len(tableName) == 0 {
+		return nil, errors.New("table name is required")
+	}
+
+	repository := &DynamoDBRepository{
+		table:            tableName,
+		cursorSecret:     []byte(os.Getenv(constantscore.CursorSecretEnv)),
+		batchConcurrency: defaultBatchConcurrency,
+		batchRetryPolicy: defaultBatchRetryPolicy,
+		timeoutPolicy:    defaultTimeoutPolicy,
+	}
+	for _, opt := range opts {
+		opt(repository)
+	}
+	if len(repository.cursorSecret) == 0 {
+		return nil, errorMissingCursorSecret
+	}
+	if repository.recordsHistory() && len(repository.partitionKeyName) == 0 {
+		return nil, errors.New("key schema is required to record history")
 	}
 
-	client := &DynamoDBClient{
-		client: dynamodb.NewFromConfig(defaultConfig),
+	if repository.client == nil {
+		client, err := repository.clientOptions.newClient(region)
+		if err != nil {
+			return nil, err
+		}
+		repository.client = client
+	}
+	if repository.clientOptions.resilient {
+		repository.client = NewResilientClient(repository.client, repository.clientOptions.resilience...)
 	}
 
-	return &DynamoDBRepository{
-		client: client,
-		table:  tableName,
-	}, nil
+	return repository, nil
+}
+
+// TableName returns the name of the table of the repository.
+func (d DynamoDBRepository) TableName() string {
+	return d.table
 }
 
 // PutItemCore put item in DynamoDB.
-func (d DynamoDBRepository) PutItemCore(ctx context.Context, request events.APIGatewayProxyRequest, item map[string]types.AttributeValue) error {
+// When versioning is enabled the incremented version is stored back into item, and so are the audit attributes with WithAudit.
+func (d DynamoDBRepository) PutItemCore(ctx context.Context, request events.APIGatewayProxyRequest, item map[string]types.AttributeValue, opts ...PutOption) error {
 	logs.LogTrackingInfo("PutItemCore", ctx, request)
+	options, conditions, errorPreparePut := d.preparePut(request, item, opts)
+	if errorPreparePut != nil {
+		logs.LogTrackingError("PutItemCore", "preparePut", ctx, request, errorPreparePut)
+		return newOperationError("PutItemCore", ErrValidation, errorPreparePut)
+	}
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("PutItemCore", "operationContext", ctx, request, errorTimeout)
+		return wrapError("PutItemCore", errorTimeout)
+	}
+	defer cancel()
+	if d.audit != nil && options.mode != PutModeCreate {
+		creationConditions, errorStampCreation := d.stampCreation(operationCtx, item)
+		if errorStampCreation != nil {
+			logs.LogTrackingError("PutItemCore", "stampCreation", ctx, request, errorStampCreation)
+			return wrapError("PutItemCore", errorStampCreation)
+		}
+		conditions = append(conditions, creationConditions...)
+	}
 	input := &dynamodb.PutItemInput{
 		Item:      item,
 		TableName: &d.table,
 	}
+	if condition, hasCondition := joinConditions(conditions); hasCondition {
+		expr, errorExpression := expression.NewBuilder().WithCondition(condition).Build()
+		if errorExpression != nil {
+			logs.LogTrackingError("PutItemCore", "expression.NewBuilder", ctx, request, errorExpression)
+			return newOperationError("PutItemCore", ErrValidation, errorExpression)
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+
 	logs.LogTrackingInfoData("PutItemCore input", input, ctx, request)
-	_, err := d.client.PutItem(ctx, input)
+	var err error
+	if d.recordsHistory() {
+		err = d.putWithHistory(operationCtx, request, input, options.mode)
+	} else {
+		_, err = d.client.PutItem(operationCtx, input)
+	}
 	if err != nil {
-		logs.LogTrackingError("CreateItemRepository", "PutItem", ctx, request, err)
-		return err
+		logs.LogTrackingError("PutItemCore", "PutItem", ctx, request, err)
+		if classifyError(err) == ErrConditionFailed {
+			if options.mode == PutModeCreate {
+				return newOperationError("PutItemCore", ErrAlreadyExists, err)
+			}
+			return newOperationError("PutItemCore", ErrVersionConflict, err)
+		}
+		return wrapError("PutItemCore", err)
 	}
 	return nil
 }
 
+// putWithHistory executes the put of input and records it in the history table in one transaction.
+func (d DynamoDBRepository) putWithHistory(ctx context.Context, request events.APIGatewayProxyRequest, input *dynamodb.PutItemInput, mode PutMode) error {
+	operation := HistoryOperationPut
+	if mode == PutModeCreate {
+		operation = HistoryOperationCreate
+	}
+	return d.writeWithHistory(ctx, request, TransactionOperationPut, types.TransactWriteItem{Put: &types.Put{
+		Item:                                input.Item,
+		TableName:                           input.TableName,
+		ConditionExpression:                 input.ConditionExpression,
+		ExpressionAttributeNames:            input.ExpressionAttributeNames,
+		ExpressionAttributeValues:           input.ExpressionAttributeValues,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}}, historyChange{operation: operation, key: d.keyOfItem(input.Item), item: input.Item})
+}
+
+// preparePut stamps the audit, TTL and version attributes on an item about to be put and returns
+// the conditions the put must meet.
+func (d DynamoDBRepository) preparePut(request events.APIGatewayProxyRequest, item map[string]types.AttributeValue, opts []PutOption) (putOptions, []expression.ConditionBuilder, error) {
+	options := putOptions{mode: PutModeUpsert, partitionKeyName: d.partitionKeyName}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if d.audit != nil {
+		d.stampPut(item, request, options.mode == PutModeCreate)
+		if options.mode != PutModeCreate && d.lacksCreation(item) && len(d.partitionKeyName) == 0 {
+			return options, nil, errors.New("key schema is required to upsert audited items without their creation attributes")
+		}
+	}
+	if !options.expiresAt.IsZero() {
+		if len(d.ttlAttribute) == 0 {
+			return options, nil, errors.New("TTL attribute is required to expire items")
+		}
+		item[d.ttlAttribute] = IntValue(options.expiresAt.Unix())
+	}
+
+	var conditions []expression.ConditionBuilder
+	if options.mode == PutModeCreate {
+		if len(options.partitionKeyName) == 0 {
+			return options, nil, errors.New("key schema is required to create items")
+		}
+		conditions = append(conditions, expression.AttributeNotExists(expression.Name(options.partitionKeyName)))
+		if len(d.versionAttribute) != 0 {
+			item[d.versionAttribute] = IntValue(1)
+		}
+	} else if len(d.versionAttribute) != 0 {
+		currentVersion, errorVersion := versionFromItem(item, d.versionAttribute)
+		if errorVersion != nil {
+			return options, nil, errorVersion
+		}
+		conditions = append(conditions, versionCondition(d.versionAttribute, currentVersion))
+		item[d.versionAttribute] = IntValue(currentVersion + 1)
+	}
+	return options, conditions, nil
+}
+
 // GetItemCore get item from DynamoDB.
-func (d DynamoDBRepository) GetItemCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string) (*dynamodb.GetItemOutput, error) {
+func (d DynamoDBRepository) GetItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...ReadOption) (*dynamodb.GetItemOutput, error) {
 	logs.LogTrackingInfo("GetItemCore", ctx, request)
+	if errorValidateKey := key.Validate(); errorValidateKey != nil {
+		logs.LogTrackingError("GetItemCore", "Validate", ctx, request, errorValidateKey)
+		return &dynamodb.GetItemOutput{}, wrapError("GetItemCore", errorValidateKey)
+	}
+	options := d.readOptions(opts)
+	projection, projectionNames, errorProjection := options.projectionExpression()
+	if errorProjection != nil {
+		logs.LogTrackingError("GetItemCore", "projectionExpression", ctx, request, errorProjection)
+		return &dynamodb.GetItemOutput{}, newOperationError("GetItemCore", ErrValidation, errorProjection)
+	}
 	input := &dynamodb.GetItemInput{
-		Key:       helpers.GetPrimaryKey(fieldNameFilterByID, fieldValueFilterByID),
-		TableName: aws.String(d.table),
+		Key:                      key.AttributeMap(),
+		TableName:                aws.String(d.table),
+		ConsistentRead:           aws.Bool(options.consistentRead),
+		ProjectionExpression:     projection,
+		ExpressionAttributeNames: projectionNames,
+	}
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("GetItemCore", "operationContext", ctx, request, errorTimeout)
+		return &dynamodb.GetItemOutput{}, wrapError("GetItemCore", errorTimeout)
 	}
-	response, err := d.client.GetItem(context.TODO(), input)
+	defer cancel()
+	response, err := d.client.GetItem(operationCtx, input)
 	if err != nil {
 		logs.LogTrackingError("GetItemCore", "GetItem", ctx, request, err)
-		return &dynamodb.GetItemOutput{}, nil
+		return &dynamodb.GetItemOutput{}, wrapError("GetItemCore", err)
+	}
+	if len(response.Item) == 0 {
+		return response, newOperationError("GetItemCore", ErrNotFound, nil)
+	}
+	if !options.includeDeleted && d.isSoftDeleted(response.Item) {
+		return &dynamodb.GetItemOutput{}, newOperationError("GetItemCore", ErrNotFound, nil)
 	}
 	return response, nil
 }
 
 // DeleteItemCore item from DynamoDB.
-func (d DynamoDBRepository) DeleteItemCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string) error {
+// With WithSoftDelete the item is marked as deleted instead, failing with ErrNotFound when there is none.
+func (d DynamoDBRepository) DeleteItemCore(ctx context.Context, request events.APIGatewayProxyRequest, key Key, opts ...DeleteOption) error {
 	logs.LogTrackingInfo("DeleteItemCore", ctx, request)
+	options := deleteOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if errorValidateKey := key.Validate(); errorValidateKey != nil {
+		logs.LogTrackingError("DeleteItemCore", "Validate", ctx, request, errorValidateKey)
+		return wrapError("DeleteItemCore", errorValidateKey)
+	}
+	if d.softDelete != nil && !options.hard {
+		return d.softDeleteItem(ctx, request, key)
+	}
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(d.table),
-		Key:       helpers.GetPrimaryKey(fieldNameFilterByID, fieldValueFilterByID),
+		Key:       key.AttributeMap(),
+	}
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("DeleteItemCore", "operationContext", ctx, request, errorTimeout)
+		return wrapError("DeleteItemCore", errorTimeout)
+	}
+	defer cancel()
+	var err error
+	if d.recordsHistory() {
+		err = d.deleteWithHistory(operationCtx, request, key, input)
+	} else {
+		_, err = d.client.DeleteItem(operationCtx, input)
 	}
-	_, err := d.client.DeleteItem(context.TODO(), input)
 	if err != nil {
 		logs.LogTrackingError("DeleteItemCore", "DeleteItem", ctx, request, err)
-		return err
+		return wrapError("DeleteItemCore", err)
 	}
 
+	return nil
+}
+
+// deleteWithHistory executes the delete of input and records it in the history table in one transaction.
+// Deleting a missing item records nothing.
+func (d DynamoDBRepository) deleteWithHistory(ctx context.Context, request events.APIGatewayProxyRequest, key Key, input *dynamodb.DeleteItemInput) error {
+	expr, err := expression.NewBuilder().WithCondition(key.existsCondition()).Build()
+	if err != nil {
+		return err
+	}
+	err = d.writeWithHistory(ctx, request, TransactionOperationDelete, types.TransactWriteItem{Delete: &types.Delete{
+		Key:                       input.Key,
+		TableName:                 input.TableName,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}}, historyChange{operation: HistoryOperationDelete, key: key})
+	if classifyError(err) == ErrConditionFailed {
+		return nil
+	}
 	return err
 }
 
 // UpdateItemCore item from DynamoDB.
-func (d DynamoDBRepository) UpdateItemCore(ctx context.Context, request events.APIGatewayProxyRequest, itemObject interface{}, fieldNameFilterByID string, fieldValueFilterByID string, skipFields []string) error {
+// By default every field of itemObject is written; WithPatch only writes the fields that are set.
+func (d DynamoDBRepository) UpdateItemCore(ctx context.Context, request events.APIGatewayProxyRequest, itemObject interface{}, key Key, skipFields []string, opts ...UpdateOption) error {
 	logs.LogTrackingInfo("UpdateItemCore", ctx, request)
-	updateValues := helpers.BuildUpdateValues(itemObject, ctx, request)
-	updateExpression, errorBuildUpdateExpression := helpers.BuildUpdateExpression(updateValues, skipFields, ctx, request)
+	options := updateOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if errorValidateKey := key.Validate(); errorValidateKey != nil {
+		logs.LogTrackingError("UpdateItemCore", "Validate", ctx, request, errorValidateKey)
+		return wrapError("UpdateItemCore", errorValidateKey)
+	}
+	var updateValues map[string]interface{}
+	removeFields := options.removeFields
+	if options.patch {
+		var nullFields []string
+		updateValues, nullFields = helpers.BuildPatchValues(itemObject, ctx, request, d.valuesOptions...)
+		removeFields = append(append([]string{}, removeFields...), nullFields...)
+	} else {
+		updateValues = helpers.BuildUpdateValues(itemObject, ctx, request, d.valuesOptions...)
+	}
+	// Key attributes can never be part of an update expression.
+	skipFields = append(append([]string{}, skipFields...), key.Names()...)
+	if len(d.versionAttribute) != 0 {
+		skipFields = append(skipFields, d.versionAttribute)
+	}
+	skipFields = append(skipFields, d.auditAttributes()...)
+	updateExpression, errorBuildUpdateExpression := helpers.BuildPatchExpression(updateValues, removeFields, skipFields, options.operations, ctx, request)
 	if errorBuildUpdateExpression != nil {
 		logs.LogTrackingError("UpdateItemCore", "BuildUpdateExpression", ctx, request, errorBuildUpdateExpression)
+		return newOperationError("UpdateItemCore", ErrValidation, errorBuildUpdateExpression)
 	}
 
-	cond := expression.Equal(
-		expression.Name(fieldNameFilterByID),
-		expression.Value(fieldValueFilterByID))
+	if d.recordsHistory() && (options.returnValues == types.ReturnValueAllOld || options.returnValues == types.ReturnValueUpdatedOld) {
+		errorReturnValues := fmt.Errorf("return values %s are not available when recording history", options.returnValues)
+		logs.LogTrackingError("UpdateItemCore", "WithReturnValues", ctx, request, errorReturnValues)
+		return newOperationError("UpdateItemCore", ErrValidation, errorReturnValues)
+	}
+	change := historyChange{operation: HistoryOperationUpdate, key: key}
+	if d.recordsHistory() {
+		var errorHistoryUpdates error
+		if change.updates, errorHistoryUpdates = historyUpdates(updateValues, removeFields, skipFields, options.operations); errorHistoryUpdates != nil {
+			logs.LogTrackingError("UpdateItemCore", "historyUpdates", ctx, request, errorHistoryUpdates)
+			return newOperationError("UpdateItemCore", ErrValidation, errorHistoryUpdates)
+		}
+	}
+
+	conditions := append([]expression.ConditionBuilder{key.existsCondition()}, d.notDeletedFilters(false)...)
+	if len(d.versionAttribute) != 0 {
+		expectedVersion, hasVersion, errorVersion := versionFromUpdateValues(updateValues, d.versionAttribute)
+		if errorVersion != nil {
+			logs.LogTrackingError("UpdateItemCore", "versionFromUpdateValues", ctx, request, errorVersion)
+			return newOperationError("UpdateItemCore", ErrValidation, errorVersion)
+		}
+		if !hasVersion {
+			// An update without the version, such as a patch or a struct lacking the field, does not check it,
+			// but still moves it forward.
+			updateExpression = updateExpression.Add(expression.Name(d.versionAttribute), expression.Value(1))
+			change.updates = append(change.updates, historyUpdate{path: d.versionAttribute, operation: helpers.UpdateOperationAdd, value: IntValue(1)})
+		} else {
+			conditions = append(conditions, versionCondition(d.versionAttribute, expectedVersion))
+			updateExpression = updateExpression.Set(expression.Name(d.versionAttribute), expression.Value(expectedVersion+1))
+			change.updates = append(change.updates, historyUpdate{path: d.versionAttribute, value: IntValue(expectedVersion + 1)})
+		}
+	}
+	if d.audit != nil {
+		updateExpression = d.stampUpdate(updateExpression, request, &change)
+	}
+	condition, _ := joinConditions(conditions)
 
-	expr, errorExpression := expression.NewBuilder().WithUpdate(updateExpression).WithCondition(cond).Build()
+	expr, errorExpression := expression.NewBuilder().WithUpdate(updateExpression).WithCondition(condition).Build()
 	if errorExpression != nil {
 		logs.LogTrackingError("UpdateItemCore", "expression.NewBuilder", ctx, request, errorExpression)
+		return newOperationError("UpdateItemCore", ErrValidation, errorExpression)
 	}
 	logs.LogTrackingInfoData("UpdateItemCore", expr, ctx, request)
 	updateItemInput := &dynamodb.UpdateItemInput{
-		Key:                       helpers.GetPrimaryKey(fieldNameFilterByID, fieldValueFilterByID),
+		Key:                       key.AttributeMap(),
 		TableName:                 aws.String(d.table),
 		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
+		// Returning the stored item tells a missing item apart from a version conflict.
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		ReturnValues:                        options.returnValues,
+	}
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("UpdateItemCore", "operationContext", ctx, request, errorTimeout)
+		return wrapError("UpdateItemCore", errorTimeout)
+	}
+	defer cancel()
+	updateItemOutput, errorUpdateItem := d.updateItem(operationCtx, request, updateItemInput, change)
+	if parents := documentParents(updateValues, skipFields); len(parents) != 0 && isInvalidDocumentPath(errorUpdateItem) {
+		// Nested paths cannot be set below a missing map, so the missing parents are created before retrying.
+		if errorUpdateItem = d.createParents(operationCtx, key, parents); errorUpdateItem == nil {
+			updateItemOutput, errorUpdateItem = d.updateItem(operationCtx, request, updateItemInput, change)
+		}
+	}
+	if errorUpdateItem != nil {
+		logs.LogTrackingError("UpdateItemCore", "UpdateItem", ctx, request, errorUpdateItem)
+		if classifyError(errorUpdateItem) == ErrConditionFailed {
+			if storedItem := conditionFailedItem(errorUpdateItem); len(storedItem) != 0 && !d.isSoftDeleted(storedItem) {
+				return newOperationError("UpdateItemCore", ErrVersionConflict, errorUpdateItem)
+			}
+			return newOperationError("UpdateItemCore", ErrNotFound, errorUpdateItem)
+		}
+		return wrapError("UpdateItemCore", errorUpdateItem)
 	}
-	_, errorUpdateItem := d.client.UpdateItem(context.TODO(), updateItemInput)
 
-	return errorUpdateItem
+	returned := updateItemOutput.Attributes
+	if d.recordsHistory() && options.returnOut != nil && len(options.returnValues) != 0 && options.returnValues != types.ReturnValueNone {
+		var errorAfterUpdate error
+		if returned, errorAfterUpdate = d.itemAfterUpdate(operationCtx, key, options.returnValues, expr.Names()); errorAfterUpdate != nil {
+			logs.LogTrackingError("UpdateItemCore", "itemAfterUpdate", ctx, request, errorAfterUpdate)
+			return wrapError("UpdateItemCore", errorAfterUpdate)
+		}
+	}
+	if options.returnOut != nil && len(returned) != 0 {
+		if errorUnmarshal := helpers.UnmarshalMapToType(returned, options.returnOut); errorUnmarshal != nil {
+			logs.LogTrackingError("UpdateItemCore", "UnmarshalMapToType", ctx, request, errorUnmarshal)
+			return newOperationError("UpdateItemCore", ErrValidation, errorUnmarshal)
+		}
+	}
+	return nil
 }
 
-// GetItemByFieldCore get item from DynamoDB.
-func (d DynamoDBRepository) GetItemByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, fieldNameFilterStatus string, fieldValueFilterStatus string) (*dynamodb.QueryOutput, error) {
-	logs.LogTrackingInfo("GetItemByFieldCore", ctx, request)
+// updateItem executes the update of input, recording change in the history table in the same transaction
+// when history is enabled. Such updates return no attributes.
+func (d DynamoDBRepository) updateItem(ctx context.Context, request events.APIGatewayProxyRequest, input *dynamodb.UpdateItemInput, change historyChange) (*dynamodb.UpdateItemOutput, error) {
+	if !d.recordsHistory() {
+		return d.client.UpdateItem(ctx, input)
+	}
+	err := d.writeWithHistory(ctx, request, TransactionOperationUpdate, types.TransactWriteItem{Update: &types.Update{
+		Key:                                 input.Key,
+		TableName:                           input.TableName,
+		UpdateExpression:                    input.UpdateExpression,
+		ConditionExpression:                 input.ConditionExpression,
+		ExpressionAttributeNames:            input.ExpressionAttributeNames,
+		ExpressionAttributeValues:           input.ExpressionAttributeValues,
+		ReturnValuesOnConditionCheckFailure: input.ReturnValuesOnConditionCheckFailure,
+	}}, change)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
 
-	keyCondition := "#" + fieldNameFilterByID + " = :" + fieldNameFilterByID + "Value"
-	//keyCondition := "#publicID = :publicIDValue AND #statusMerchant = :statusMerchantValue"
+// historyValues marshals the values written by an update for its history record.
+func historyValues(updateValues map[string]interface{}, skipFields []string) (map[string]types.AttributeValue, error) {
+	values := make(map[string]types.AttributeValue, len(updateValues))
+	for fieldName, value := range updateValues {
+		if helpers.SkipUpdatingFields(fieldName, skipFields) {
+			continue
+		}
+		marshalled, err := attributevalue.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %s: %w", fieldName, err)
+		}
+		values[fieldName] = marshalled
+	}
+	return values, nil
+}
 
-	// Definir los nombres de atributos de expresión
-	exprAttrNames := map[string]string{
-		"#" + fieldNameFilterByID: fieldNameFilterByID,
-		//"#" + fieldNameFilterStatus: fieldNameFilterStatus,
+// documentParents returns the parent paths of the nested fields written by an update, grouped by depth.
+func documentParents(updateValues map[string]interface{}, skipFields []string) [][]string {
+	var parents [][]string
+	seen := make(map[string]bool)
+	for fieldName := range updateValues {
+		if helpers.SkipUpdatingFields(fieldName, skipFields) {
+			continue
+		}
+		elements := strings.Split(fieldName, ".")
+		for depth := 1; depth < len(elements); depth++ {
+			parent := strings.Join(elements[:depth], ".")
+			if strings.Contains(parent, "[") {
+				break
+			}
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			for len(parents) < depth {
+				parents = append(parents, nil)
+			}
+			parents[depth-1] = append(parents[depth-1], parent)
+		}
 	}
+	return parents
+}
 
-	// Definir los valores de atributos de expresión
-	exprAttrValues := map[string]types.AttributeValue{
-		":" + fieldNameFilterByID + "Value": &types.AttributeValueMemberS{Value: fieldValueFilterByID},
-		//":" + fieldNameFilterStatus + "Value": &types.AttributeValueMemberS{Value: fieldValueFilterStatus},
+// createParents sets every missing parent map to an empty map, one depth at a time since overlapping
+// paths cannot be written by the same update. It fails like the update when the item does not exist.
+func (d DynamoDBRepository) createParents(ctx context.Context, key Key, parents [][]string) error {
+	condition, _ := joinConditions(append([]expression.ConditionBuilder{key.existsCondition()}, d.notDeletedFilters(false)...))
+	for _, level := range parents {
+		update := expression.UpdateBuilder{}
+		for _, parent := range level {
+			name := expression.Name(parent)
+			update = update.Set(name, expression.IfNotExists(name, expression.Value(map[string]interface{}{})))
+		}
+		expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+		if err != nil {
+			return err
+		}
+		_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			Key:                                 key.AttributeMap(),
+			TableName:                           aws.String(d.table),
+			ConditionExpression:                 expr.Condition(),
+			ExpressionAttributeNames:            expr.Names(),
+			ExpressionAttributeValues:           expr.Values(),
+			UpdateExpression:                    expr.Update(),
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		})
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	input := &dynamodb.QueryInput{
-		IndexName:                 aws.String(globalSecondaryIndex),
-		TableName:                 aws.String(d.table),
-		KeyConditionExpression:    aws.String(keyCondition),
-		ExpressionAttributeNames:  exprAttrNames,
-		ExpressionAttributeValues: exprAttrValues,
+// GetItemByFieldCore get item from DynamoDB.
+// When fieldNameFilterStatus is set, only the items whose status field equals fieldValueFilterStatus are returned.
+func (d DynamoDBRepository) GetItemByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, fieldNameFilterStatus string, fieldValueFilterStatus string) (*dynamodb.QueryOutput, error) {
+	logs.LogTrackingInfo("GetItemByFieldCore", ctx, request)
+
+	query := NewQuery(fieldNameFilterByID, fieldValueFilterByID).Index(globalSecondaryIndex)
+	if len(fieldNameFilterStatus) != 0 {
+		query.FilterEquals(fieldNameFilterStatus, fieldValueFilterStatus)
+	}
+	input, errorBuild := query.build(d.table, d.notDeletedFilters(false)...)
+	if errorBuild != nil {
+		logs.LogTrackingError("GetItemByFieldCore", "build", ctx, request, errorBuild)
+		return &dynamodb.QueryOutput{}, newOperationError("GetItemByFieldCore", ErrValidation, errorBuild)
 	}
 	logs.LogTrackingInfoData("GetItemByFieldCore input", input, ctx, request)
-	response, err := d.client.GetItemByField(context.TODO(), input)
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("GetItemByFieldCore", "operationContext", ctx, request, errorTimeout)
+		return &dynamodb.QueryOutput{}, wrapError("GetItemByFieldCore", errorTimeout)
+	}
+	defer cancel()
+	response, err := d.client.GetItemByField(operationCtx, input)
 	logs.LogTrackingInfoData("GetItemByFieldCore response", response, ctx, request) //TODO
 
 	if err != nil {
 		logs.LogTrackingError("GetItemByFieldCore", "GetItemByField", ctx, request, err)
-		return &dynamodb.QueryOutput{}, nil
+		return &dynamodb.QueryOutput{}, wrapError("GetItemByFieldCore", err)
 	}
 	return response, nil
 }
+
+// GetItemsByFieldPageCore get one page of items from DynamoDB starting at the given cursor.
+func (d DynamoDBRepository) GetItemsByFieldPageCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, cursor string) (*QueryPage, error) {
+	logs.LogTrackingInfo("GetItemsByFieldPageCore", ctx, request)
+	return d.QueryCore(ctx, request, NewQuery(fieldNameFilterByID, fieldValueFilterByID).Index(globalSecondaryIndex).Limit(pageSize), cursor)
+}
+
+// GetAllItemsByFieldCore iterate over every page of items from DynamoDB calling handlePage for each one.
+func (d DynamoDBRepository) GetAllItemsByFieldCore(ctx context.Context, request events.APIGatewayProxyRequest, fieldNameFilterByID string, fieldValueFilterByID string, globalSecondaryIndex string, pageSize int32, handlePage func(items []map[string]types.AttributeValue) error) error {
+	logs.LogTrackingInfo("GetAllItemsByFieldCore", ctx, request)
+	return d.QueryAllCore(ctx, request, NewQuery(fieldNameFilterByID, fieldValueFilterByID).Index(globalSecondaryIndex).Limit(pageSize), handlePage)
+}
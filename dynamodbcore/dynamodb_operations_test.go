@@ -0,0 +1,419 @@
+package dynamodbcore_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type payment struct {
+	ID      string `dynamodbav:"id"`
+	Status  string `dynamodbav:"status"`
+	Amount  int    `dynamodbav:"amount"`
+	Version int    `dynamodbav:"version"`
+}
+
+func newTestRepository(t *testing.T, opts ...dynamodbcore.Option) (*dynamodbcore.DynamoDBRepository, *dynamodbtest.Client) {
+	t.Helper()
+	client := dynamodbtest.NewClient(dynamodbtest.TableDefinition{
+		Name:         "payments",
+		PartitionKey: "id",
+		GlobalSecondaryIndexes: []dynamodbtest.IndexDefinition{
+			{Name: "status-index", PartitionKey: "status", SortKey: "id"},
+		},
+	})
+	opts = append([]dynamodbcore.Option{
+		dynamodbcore.WithClient(client),
+		dynamodbcore.WithCursorSecret([]byte("test-secret")),
+		dynamodbcore.WithKeySchema("id", ""),
+	}, opts...)
+	repository, err := dynamodbcore.NewDynamoDBRepository("payments", "us-east-1", opts...)
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository error = %v", err)
+	}
+	return repository, client
+}
+
+func paymentItem(id string, status string, version int) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"id":     dynamodbcore.StringValue(id),
+		"status": dynamodbcore.StringValue(status),
+		"amount": dynamodbcore.IntValue(100),
+	}
+	if version != 0 {
+		item["version"] = dynamodbcore.IntValue(int64(version))
+	}
+	return item
+}
+
+func TestPutItemCoreCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  bool
+		wantErr error
+	}{
+		{name: "new key", stored: false},
+		{name: "existing key", stored: true, wantErr: dynamodbcore.ErrAlreadyExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository, _ := newTestRepository(t)
+			ctx := context.Background()
+			if test.stored {
+				if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PAID", 0)); err != nil {
+					t.Fatalf("PutItemCore error = %v", err)
+				}
+			}
+			err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PENDING", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate))
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Errorf("PutItemCore error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	repository, _ := newTestRepository(t)
+	ctx := context.Background()
+	key := dynamodbcore.NewStringKey("id", "missing")
+	tests := []struct {
+		name      string
+		operation func() error
+	}{
+		{name: "get", operation: func() error {
+			_, err := repository.GetItemCore(ctx, events.APIGatewayProxyRequest{}, key)
+			return err
+		}},
+		{name: "update", operation: func() error {
+			return repository.UpdateItemCore(ctx, events.APIGatewayProxyRequest{}, payment{ID: "missing", Status: "PAID"}, key, nil)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.operation(); !errors.Is(err, dynamodbcore.ErrNotFound) {
+				t.Errorf("error = %v, want %v", err, dynamodbcore.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestUpdateItemCoreVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		item        interface{}
+		wantErr     error
+		wantVersion string
+	}{
+		{name: "current version", item: payment{ID: "p1", Status: "PAID", Version: 1}, wantVersion: "2"},
+		{name: "stale version", item: payment{ID: "p1", Status: "PAID", Version: 3}, wantErr: dynamodbcore.ErrVersionConflict, wantVersion: "1"},
+		{name: "without version", item: struct {
+			ID     string `dynamodbav:"id"`
+			Status string `dynamodbav:"status"`
+		}{ID: "p1", Status: "PAID"}, wantVersion: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository, _ := newTestRepository(t, dynamodbcore.WithVersionAttribute("version"))
+			ctx := context.Background()
+			// A put without version creates the item at version 1.
+			if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PENDING", 0)); err != nil {
+				t.Fatalf("PutItemCore error = %v", err)
+			}
+			key := dynamodbcore.NewStringKey("id", "p1")
+			err := repository.UpdateItemCore(ctx, events.APIGatewayProxyRequest{}, test.item, key, []string{"id"})
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("UpdateItemCore error = %v, want %v", err, test.wantErr)
+			}
+			response, err := repository.GetItemCore(ctx, events.APIGatewayProxyRequest{}, key)
+			if err != nil {
+				t.Fatalf("GetItemCore error = %v", err)
+			}
+			if version, _ := response.Item["version"].(*types.AttributeValueMemberN); version == nil || version.Value != test.wantVersion {
+				t.Errorf("version = %#v, want %s", response.Item["version"], test.wantVersion)
+			}
+		})
+	}
+}
+
+func TestQueryCorePaging(t *testing.T) {
+	repository, _ := newTestRepository(t)
+	ctx := context.Background()
+	for _, id := range []string{"p1", "p2", "p3", "p4", "p5"} {
+		status := "PAID"
+		if id == "p3" {
+			status = "PENDING"
+		}
+		if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem(id, status, 0)); err != nil {
+			t.Fatalf("PutItemCore error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pageSize int32
+		want     []int
+	}{
+		{name: "exact pages", pageSize: 2, want: []int{2, 2}},
+		{name: "partial last page", pageSize: 3, want: []int{3, 1}},
+		{name: "single page", pageSize: 10, want: []int{4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			cursor := ""
+			for {
+				page, err := repository.QueryCore(ctx, events.APIGatewayProxyRequest{}, dynamodbcore.NewQuery("status", "PAID").Index("status-index").Limit(test.pageSize), cursor)
+				if err != nil {
+					t.Fatalf("QueryCore error = %v", err)
+				}
+				if len(page.Items) != 0 {
+					got = append(got, len(page.Items))
+				}
+				if len(page.NextCursor) == 0 {
+					break
+				}
+				if len(got) > 5 {
+					t.Fatalf("QueryCore did not stop paging")
+				}
+				cursor = page.NextCursor
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("page sizes = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("page sizes = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+
+	if _, err := repository.QueryCore(ctx, events.APIGatewayProxyRequest{}, dynamodbcore.NewQuery("status", "PAID").Index("status-index"), "tampered"); !errors.Is(err, dynamodbcore.ErrValidation) {
+		t.Errorf("QueryCore with a tampered cursor error = %v, want %v", err, dynamodbcore.ErrValidation)
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	repository, client := newTestRepository(t, dynamodbcore.WithTimeoutPolicy(dynamodbcore.TimeoutPolicy{OperationTimeout: 10 * time.Millisecond}))
+	client.Latency = time.Second
+	_, err := repository.GetItemCore(context.Background(), events.APIGatewayProxyRequest{}, dynamodbcore.NewStringKey("id", "p1"))
+	if !errors.Is(err, dynamodbcore.ErrTimeout) {
+		t.Errorf("GetItemCore error = %v, want %v", err, dynamodbcore.ErrTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	repository, _ = newTestRepository(t, dynamodbcore.WithTimeoutPolicy(dynamodbcore.TimeoutPolicy{SafetyMargin: time.Second}))
+	if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PAID", 0)); !errors.Is(err, dynamodbcore.ErrTimeout) {
+		t.Errorf("PutItemCore without budget error = %v, want %v", err, dynamodbcore.ErrTimeout)
+	}
+}
+
+func TestUpdateItemCoreNestedPatch(t *testing.T) {
+	type address struct {
+		City    string `dynamodbav:"city"`
+		Country string `dynamodbav:"country"`
+	}
+	type billing struct {
+		Address address `dynamodbav:"address"`
+	}
+	type patch struct {
+		Billing billing `dynamodbav:"billing"`
+	}
+	tests := []struct {
+		name   string
+		stored map[string]types.AttributeValue
+		want   map[string]types.AttributeValue
+	}{
+		{
+			name:   "missing parents",
+			stored: map[string]types.AttributeValue{},
+			want:   map[string]types.AttributeValue{"city": dynamodbcore.StringValue("Quito")},
+		},
+		{
+			name: "existing siblings",
+			stored: map[string]types.AttributeValue{"billing": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"country": dynamodbcore.StringValue("EC")}},
+			}}},
+			want: map[string]types.AttributeValue{"city": dynamodbcore.StringValue("Quito"), "country": dynamodbcore.StringValue("EC")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository, _ := newTestRepository(t)
+			ctx := context.Background()
+			item := paymentItem("p1", "PAID", 0)
+			for name, value := range test.stored {
+				item[name] = value
+			}
+			if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, item); err != nil {
+				t.Fatalf("PutItemCore error = %v", err)
+			}
+			key := dynamodbcore.NewStringKey("id", "p1")
+			if err := repository.UpdateItemCore(ctx, events.APIGatewayProxyRequest{}, patch{Billing: billing{Address: address{City: "Quito"}}}, key, nil, dynamodbcore.WithPatch()); err != nil {
+				t.Fatalf("UpdateItemCore error = %v", err)
+			}
+			response, err := repository.GetItemCore(ctx, events.APIGatewayProxyRequest{}, key)
+			if err != nil {
+				t.Fatalf("GetItemCore error = %v", err)
+			}
+			stored := response.Item["billing"].(*types.AttributeValueMemberM).Value["address"].(*types.AttributeValueMemberM).Value
+			if len(stored) != len(test.want) {
+				t.Fatalf("billing.address = %#v, want %#v", stored, test.want)
+			}
+			for name, value := range test.want {
+				if got, _ := stored[name].(*types.AttributeValueMemberS); got == nil || got.Value != value.(*types.AttributeValueMemberS).Value {
+					t.Errorf("billing.address.%s = %#v, want %#v", name, stored[name], value)
+				}
+			}
+		})
+	}
+
+	repository, _ := newTestRepository(t)
+	if err := repository.UpdateItemCore(context.Background(), events.APIGatewayProxyRequest{}, patch{Billing: billing{Address: address{City: "Quito"}}}, dynamodbcore.NewStringKey("id", "missing"), nil, dynamodbcore.WithPatch()); !errors.Is(err, dynamodbcore.ErrNotFound) {
+		t.Errorf("UpdateItemCore of a missing item error = %v, want %v", err, dynamodbcore.ErrNotFound)
+	}
+}
+
+func TestUpdateItemCoreFieldNames(t *testing.T) {
+	type untagged struct {
+		ID     string
+		Status string
+	}
+	tests := []struct {
+		name      string
+		opts      []dynamodbcore.Option
+		attribute string
+	}{
+		{name: "go field names", attribute: "Status"},
+		{name: "lower case field names", opts: []dynamodbcore.Option{dynamodbcore.WithLowerCaseFieldNames()}, attribute: "status"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository, _ := newTestRepository(t, test.opts...)
+			ctx := context.Background()
+			if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PENDING", 0)); err != nil {
+				t.Fatalf("PutItemCore error = %v", err)
+			}
+			key := dynamodbcore.NewStringKey("id", "p1")
+			if err := repository.UpdateItemCore(ctx, events.APIGatewayProxyRequest{}, untagged{Status: "PAID"}, key, []string{"ID", "id"}); err != nil {
+				t.Fatalf("UpdateItemCore error = %v", err)
+			}
+			response, err := repository.GetItemCore(ctx, events.APIGatewayProxyRequest{}, key)
+			if err != nil {
+				t.Fatalf("GetItemCore error = %v", err)
+			}
+			if status, _ := response.Item[test.attribute].(*types.AttributeValueMemberS); status == nil || status.Value != "PAID" {
+				t.Errorf("%s = %#v, want PAID", test.attribute, response.Item[test.attribute])
+			}
+		})
+	}
+}
+
+func TestSoftDeletedReads(t *testing.T) {
+	repository, _ := newTestRepository(t, dynamodbcore.WithSoftDelete(dynamodbcore.SoftDeletePolicy{}))
+	ctx := context.Background()
+	for _, id := range []string{"p1", "p2"} {
+		if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem(id, "PAID", 0)); err != nil {
+			t.Fatalf("PutItemCore error = %v", err)
+		}
+	}
+	if err := repository.DeleteItemCore(ctx, events.APIGatewayProxyRequest{}, dynamodbcore.NewStringKey("id", "p1")); err != nil {
+		t.Fatalf("DeleteItemCore error = %v", err)
+	}
+	items := []dynamodbcore.TransactGetItem{{Key: dynamodbcore.NewStringKey("id", "p1")}, {Key: dynamodbcore.NewStringKey("id", "p2")}}
+
+	tests := []struct {
+		name  string
+		opts  []dynamodbcore.ReadOption
+		found []bool
+	}{
+		{name: "skips soft-deleted items", found: []bool{false, true}},
+		{name: "includes soft-deleted items", opts: []dynamodbcore.ReadOption{dynamodbcore.WithIncludeDeleted()}, found: []bool{true, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := repository.TransactGetCore(ctx, events.APIGatewayProxyRequest{}, items, test.opts...)
+			if err != nil {
+				t.Fatalf("TransactGetCore error = %v", err)
+			}
+			for index, found := range test.found {
+				if (result[index] != nil) != found {
+					t.Errorf("TransactGetCore item %d = %v, want found %t", index, result[index], found)
+				}
+			}
+		})
+	}
+
+	if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PAID", 0), dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)); !errors.Is(err, dynamodbcore.ErrAlreadyExists) {
+		t.Errorf("PutItemCore create on a soft-deleted key error = %v, want %v", err, dynamodbcore.ErrAlreadyExists)
+	}
+}
+
+func TestSoftDeleteRejectsBatchDeletes(t *testing.T) {
+	repository, client := newTestRepository(t, dynamodbcore.WithSoftDelete(dynamodbcore.SoftDeletePolicy{}))
+	ctx := context.Background()
+	if err := repository.BatchWriteCore(ctx, events.APIGatewayProxyRequest{}, []map[string]types.AttributeValue{paymentItem("p1", "PAID", 0)}, nil); err != nil {
+		t.Fatalf("BatchWriteCore puts error = %v", err)
+	}
+	err := repository.BatchWriteCore(ctx, events.APIGatewayProxyRequest{}, nil, []dynamodbcore.Key{dynamodbcore.NewStringKey("id", "p1")})
+	if !errors.Is(err, dynamodbcore.ErrValidation) {
+		t.Fatalf("BatchWriteCore deletes error = %v, want %v", err, dynamodbcore.ErrValidation)
+	}
+	if items := client.Items("payments"); len(items) != 1 {
+		t.Errorf("table has %d items after a rejected delete, want 1", len(items))
+	}
+}
+
+func TestCachedRepositoryCopiesItems(t *testing.T) {
+	repository, _ := newTestRepository(t)
+	ctx := context.Background()
+	item := paymentItem("p1", "PAID", 0)
+	item["address"] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": dynamodbcore.StringValue("Quito")}}
+	item["tags"] = &types.AttributeValueMemberSS{Value: []string{"card"}}
+	if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, item); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	cached := dynamodbcore.NewCachedRepository(repository)
+	key := dynamodbcore.NewStringKey("id", "p1")
+
+	// Changing the nested values of the read item must not change the cached one, nor the next read.
+	for read := 0; read < 2; read++ {
+		response, err := cached.GetItemCore(ctx, events.APIGatewayProxyRequest{}, key)
+		if err != nil {
+			t.Fatalf("GetItemCore error = %v", err)
+		}
+		address := response.Item["address"].(*types.AttributeValueMemberM)
+		if city := address.Value["city"].(*types.AttributeValueMemberS).Value; city != "Quito" {
+			t.Fatalf("read %d address.city = %s, want Quito", read, city)
+		}
+		if tags := response.Item["tags"].(*types.AttributeValueMemberSS).Value; tags[0] != "card" {
+			t.Fatalf("read %d tags = %v, want [card]", read, tags)
+		}
+		address.Value["city"] = dynamodbcore.StringValue("Guayaquil")
+		response.Item["tags"].(*types.AttributeValueMemberSS).Value[0] = "cash"
+	}
+}
+
+func TestTransactGetCoreProjection(t *testing.T) {
+	repository, _ := newTestRepository(t, dynamodbcore.WithSoftDelete(dynamodbcore.SoftDeletePolicy{}))
+	ctx := context.Background()
+	if err := repository.PutItemCore(ctx, events.APIGatewayProxyRequest{}, paymentItem("p1", "PAID", 0)); err != nil {
+		t.Fatalf("PutItemCore error = %v", err)
+	}
+	items := []dynamodbcore.TransactGetItem{{Key: dynamodbcore.NewStringKey("id", "p1")}}
+	result, err := repository.TransactGetCore(ctx, events.APIGatewayProxyRequest{}, items, dynamodbcore.WithProjection("id", "status"))
+	if err != nil {
+		t.Fatalf("TransactGetCore error = %v", err)
+	}
+	want := map[string]types.AttributeValue{"id": dynamodbcore.StringValue("p1"), "status": dynamodbcore.StringValue("PAID")}
+	if !reflect.DeepEqual(result[0], want) {
+		t.Errorf("TransactGetCore item = %#v, want %#v", result[0], want)
+	}
+}
@@ -0,0 +1,337 @@
+package dynamodbcore
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"time"
+)
+
+// Option configures a DynamoDBRepository.
+type Option func(*DynamoDBRepository)
+
+// WithCursorSecret sets the secret used to sign pagination cursors, instead of the one in CURSOR_SECRET.
+// Every instance of a service must share it, so that a cursor issued by one is accepted by the others.
+func WithCursorSecret(secret []byte) Option {
+	return func(d *DynamoDBRepository) {
+		d.cursorSecret = secret
+	}
+}
+
+// WithVersionAttribute enables optimistic locking using the given numeric attribute.
+// Every write increments it and fails with ErrVersionConflict when the stored version has moved on.
+// Updates whose values lack the version attribute skip the check and only increment it.
+func WithVersionAttribute(versionAttribute string) Option {
+	return func(d *DynamoDBRepository) {
+		d.versionAttribute = versionAttribute
+	}
+}
+
+// WithLowerCaseFieldNames names the attributes of untagged struct fields written by UpdateItemCore with a
+// lower case first letter, as updates did before dynamodbav tags were honored. Without it they take the
+// Go field name, as PutItemCore does.
+func WithLowerCaseFieldNames() Option {
+	return func(d *DynamoDBRepository) {
+		d.valuesOptions = append(d.valuesOptions, helpers.WithLowerCaseFieldNames())
+	}
+}
+
+// WithKeySchema sets the key attribute names of the table, required by create-only puts.
+// Leave sortKeyName empty for tables without a sort key.
+func WithKeySchema(partitionKeyName string, sortKeyName string) Option {
+	return func(d *DynamoDBRepository) {
+		d.partitionKeyName = partitionKeyName
+		d.sortKeyName = sortKeyName
+	}
+}
+
+// PutMode defines how PutItemCore behaves when an item already exists at the key.
+type PutMode int
+
+const (
+	// PutModeUpsert replaces any existing item at the key.
+	PutModeUpsert PutMode = iota
+	// PutModeCreate fails with ErrAlreadyExists when an item already exists at the key, soft-deleted or not.
+	PutModeCreate
+)
+
+// PutOption configures a single PutItemCore call.
+type PutOption func(*putOptions)
+
+// putOptions holds the per-call settings of PutItemCore.
+type putOptions struct {
+	mode             PutMode
+	partitionKeyName string
+	expiresAt        time.Time
+}
+
+// WithPutMode selects between upsert and create-only behavior.
+func WithPutMode(mode PutMode) PutOption {
+	return func(o *putOptions) {
+		o.mode = mode
+	}
+}
+
+// WithTTL makes the item expire after ttl, see WithTTLAttribute.
+func WithTTL(ttl time.Duration) PutOption {
+	return WithExpiresAt(time.Now().Add(ttl))
+}
+
+// WithExpiresAt makes the item expire at expiresAt, see WithTTLAttribute.
+// DynamoDB removes expired items in the background, usually within a few days.
+func WithExpiresAt(expiresAt time.Time) PutOption {
+	return func(o *putOptions) {
+		o.expiresAt = expiresAt
+	}
+}
+
+// WithTTLAttribute sets the numeric attribute configured as the time to live of the table,
+// which WithTTL and WithExpiresAt fill with the expiration time in epoch seconds.
+func WithTTLAttribute(ttlAttribute string) Option {
+	return func(d *DynamoDBRepository) {
+		d.ttlAttribute = ttlAttribute
+	}
+}
+
+// WithBatchConcurrency sets how many batch chunks are sent to DynamoDB at the same time.
+func WithBatchConcurrency(concurrency int) Option {
+	return func(d *DynamoDBRepository) {
+		if concurrency > 0 {
+			d.batchConcurrency = concurrency
+		}
+	}
+}
+
+// WithBatchRetryPolicy sets how unprocessed batch items are retried.
+// Fields that are not positive take the default, and MaxBackoff is raised to at least InitialBackoff.
+func WithBatchRetryPolicy(policy BatchRetryPolicy) Option {
+	return func(d *DynamoDBRepository) {
+		if policy.InitialBackoff <= 0 {
+			policy.InitialBackoff = defaultBatchRetryPolicy.InitialBackoff
+		}
+		if policy.MaxBackoff <= 0 {
+			policy.MaxBackoff = defaultBatchRetryPolicy.MaxBackoff
+		}
+		if policy.MaxBackoff < policy.InitialBackoff {
+			policy.MaxBackoff = policy.InitialBackoff
+		}
+		if policy.MaxDuration <= 0 {
+			policy.MaxDuration = defaultBatchRetryPolicy.MaxDuration
+		}
+		d.batchRetryPolicy = policy
+	}
+}
+
+// WithTimeoutPolicy sets the time budget of every DynamoDB call.
+func WithTimeoutPolicy(policy TimeoutPolicy) Option {
+	return func(d *DynamoDBRepository) {
+		d.timeoutPolicy = policy
+	}
+}
+
+// clientOptions holds the settings used to build the DynamoDB client of a repository.
+type clientOptions struct {
+	awsConfig   *aws.Config
+	endpoint    string
+	credentials aws.CredentialsProvider
+	retryer     func() aws.Retryer
+	httpClient  aws.HTTPClient
+	resilience  []ResilientOption
+	resilient   bool
+}
+
+// newClient builds a DynamoDB client, loading the default AWS config when none was provided.
+func (o clientOptions) newClient(region string) (*DynamoDBClient, error) {
+	var cfg aws.Config
+	if o.awsConfig != nil {
+		cfg = o.awsConfig.Copy()
+		if len(region) != 0 {
+			cfg.Region = region
+		}
+	} else {
+		defaultConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+		if err != nil {
+			return nil, fmt.Errorf("load default config: %w", err)
+		}
+		cfg = defaultConfig
+	}
+
+	return NewDynamoDBClient(cfg, func(options *dynamodb.Options) {
+		if len(o.endpoint) != 0 {
+			options.BaseEndpoint = aws.String(o.endpoint)
+		}
+		if o.credentials != nil {
+			options.Credentials = o.credentials
+		}
+		if o.retryer != nil {
+			options.Retryer = o.retryer()
+		} else if o.resilient {
+			// The ResilientClient retries on its own, so the SDK must not retry every attempt again.
+			options.Retryer = aws.NopRetryer{}
+		}
+		if o.httpClient != nil {
+			options.HTTPClient = o.httpClient
+		}
+	}), nil
+}
+
+// WithClient injects the client used by the repository, sharing it across tables or replacing it in tests.
+// The other client options are ignored when a client is injected.
+func WithClient(client DynamoDBClientInterface) Option {
+	return func(d *DynamoDBRepository) {
+		d.client = client
+	}
+}
+
+// WithAWSConfig uses an already loaded AWS config instead of loading the default one.
+func WithAWSConfig(cfg aws.Config) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.awsConfig = &cfg
+	}
+}
+
+// WithEndpoint points the client at a custom endpoint such as DynamoDB Local.
+func WithEndpoint(endpoint string) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.endpoint = endpoint
+	}
+}
+
+// WithStaticCredentials uses fixed credentials instead of the default credential chain.
+func WithStaticCredentials(accessKeyID string, secretAccessKey string, sessionToken string) Option {
+	return WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken))
+}
+
+// WithCredentialsProvider uses the given credentials provider instead of the default credential chain.
+func WithCredentialsProvider(provider aws.CredentialsProvider) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.credentials = provider
+	}
+}
+
+// WithRetryer sets the retryer used by the client.
+func WithRetryer(retryer func() aws.Retryer) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.retryer = retryer
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send requests.
+func WithHTTPClient(httpClient aws.HTTPClient) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.httpClient = httpClient
+	}
+}
+
+// WithResilience wraps the client of the repository, injected or not, in a ResilientClient.
+// A client built by the repository then uses aws.NopRetryer unless WithRetryer is given, so that calls
+// are not retried by both the SDK and the ResilientClient. An injected client keeps its own retryer.
+func WithResilience(opts ...ResilientOption) Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.resilient = true
+		d.clientOptions.resilience = append(d.clientOptions.resilience, opts...)
+	}
+}
+
+// UpdateOption configures a single UpdateItemCore call.
+type UpdateOption func(*updateOptions)
+
+// updateOptions holds the per-call settings of UpdateItemCore.
+type updateOptions struct {
+	patch        bool
+	removeFields []string
+	operations   map[string]helpers.UpdateOperation
+	returnValues types.ReturnValue
+	returnOut    interface{}
+}
+
+// WithPatch only writes the fields that are set: nil pointers and zero values are left untouched,
+// nested structs only write their set fields, and map keys holding nil remove the attribute.
+// A struct field can therefore not be patched to its zero value: use a pointer field to write
+// zero values, and WithRemoveFields to remove attributes when patching with a struct.
+func WithPatch() UpdateOption {
+	return func(o *updateOptions) {
+		o.patch = true
+	}
+}
+
+// WithRemoveFields removes the given attributes from the item.
+func WithRemoveFields(fields ...string) UpdateOption {
+	return func(o *updateOptions) {
+		o.removeFields = append(o.removeFields, fields...)
+	}
+}
+
+// WithFieldOperation writes a field with the given operation instead of replacing it,
+// for example helpers.UpdateOperationAdd for atomic increments.
+func WithFieldOperation(field string, operation helpers.UpdateOperation) UpdateOption {
+	return func(o *updateOptions) {
+		if o.operations == nil {
+			o.operations = make(map[string]helpers.UpdateOperation)
+		}
+		o.operations[field] = operation
+	}
+}
+
+// WithReturnValues asks DynamoDB for the item attributes selected by returnValues and unmarshals them into out,
+// which must be a pointer. types.ReturnValueAllNew returns the item as stored after the update.
+// When history is recorded the update runs in a transaction, which returns no values: the new values are
+// then read right after the update, so they may include later writes, and the old values are rejected.
+func WithReturnValues(returnValues types.ReturnValue, out interface{}) UpdateOption {
+	return func(o *updateOptions) {
+		o.returnValues = returnValues
+		o.returnOut = out
+	}
+}
+
+// ReadOption configures a single GetItemCore or BatchGetCore call.
+type ReadOption func(*readOptions)
+
+// readOptions holds the per-call settings of reads.
+type readOptions struct {
+	consistentRead bool
+	projection     []string
+	includeDeleted bool
+}
+
+// WithConsistentRead reads the item with strong consistency, reflecting every write acknowledged before the read.
+func WithConsistentRead() ReadOption {
+	return func(o *readOptions) {
+		o.consistentRead = true
+	}
+}
+
+// WithProjection only reads the given attributes, which may be nested paths such as address.city.
+// An item holding none of them is reported as not found, so include a key attribute when that matters.
+func WithProjection(names ...string) ReadOption {
+	return func(o *readOptions) {
+		o.projection = append(o.projection, names...)
+	}
+}
+
+// newReadOptions applies the read options.
+func newReadOptions(opts []ReadOption) readOptions {
+	options := readOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// projectionExpression build the projection expression and its attribute names, if any.
+func (o readOptions) projectionExpression() (*string, map[string]string, error) {
+	if len(o.projection) == 0 {
+		return nil, nil, nil
+	}
+	expr, err := expression.NewBuilder().WithProjection(projectionOf(o.projection)).Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return expr.Projection(), expr.Names(), nil
+}
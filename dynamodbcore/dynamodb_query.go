@@ -0,0 +1,252 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+)
+
+// QueryBuilder describes a query on the table or one of its indexes.
+// Values are plain Go values marshalled like attributevalue.Marshal does.
+type QueryBuilder struct {
+	indexName    string
+	keyCondition expression.KeyConditionBuilder
+	hasSortKey   bool
+	filters      []expression.ConditionBuilder
+	projection   []string
+	descending   bool
+	consistent   bool
+	withDeleted  bool
+	limit        int32
+	err          error
+}
+
+// NewQuery starts a query matching the items whose partition key equals partitionKeyValue.
+func NewQuery(partitionKeyName string, partitionKeyValue interface{}) *QueryBuilder {
+	query := &QueryBuilder{
+		keyCondition: expression.Key(partitionKeyName).Equal(expression.Value(partitionKeyValue)),
+	}
+	if len(partitionKeyName) == 0 {
+		query.err = errors.New("query partition key name is required")
+	}
+	return query
+}
+
+// Index queries the given global or local secondary index instead of the table.
+func (q *QueryBuilder) Index(indexName string) *QueryBuilder {
+	q.indexName = indexName
+	return q
+}
+
+// SortKeyEquals matches the items whose sort key equals value.
+func (q *QueryBuilder) SortKeyEquals(name string, value interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).Equal(expression.Value(value)))
+}
+
+// SortKeyLessThan matches the items whose sort key is lower than value.
+func (q *QueryBuilder) SortKeyLessThan(name string, value interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).LessThan(expression.Value(value)))
+}
+
+// SortKeyLessThanEqual matches the items whose sort key is lower than or equal to value.
+func (q *QueryBuilder) SortKeyLessThanEqual(name string, value interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).LessThanEqual(expression.Value(value)))
+}
+
+// SortKeyGreaterThan matches the items whose sort key is greater than value.
+func (q *QueryBuilder) SortKeyGreaterThan(name string, value interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).GreaterThan(expression.Value(value)))
+}
+
+// SortKeyGreaterThanEqual matches the items whose sort key is greater than or equal to value.
+func (q *QueryBuilder) SortKeyGreaterThanEqual(name string, value interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).GreaterThanEqual(expression.Value(value)))
+}
+
+// SortKeyBetween matches the items whose sort key is between lower and upper, both included.
+func (q *QueryBuilder) SortKeyBetween(name string, lower interface{}, upper interface{}) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).Between(expression.Value(lower), expression.Value(upper)))
+}
+
+// SortKeyBeginsWith matches the items whose string sort key starts with prefix.
+func (q *QueryBuilder) SortKeyBeginsWith(name string, prefix string) *QueryBuilder {
+	return q.withSortKey(expression.Key(name).BeginsWith(prefix))
+}
+
+// withSortKey adds the sort key condition, only one is allowed per query.
+func (q *QueryBuilder) withSortKey(condition expression.KeyConditionBuilder) *QueryBuilder {
+	if q.hasSortKey {
+		q.err = errors.New("query accepts a single sort key condition")
+		return q
+	}
+	q.hasSortKey = true
+	q.keyCondition = expression.KeyAnd(q.keyCondition, condition)
+	return q
+}
+
+// Filter keeps the items matching condition, combined with the other filters using AND.
+// Filters are applied after the items are read, so they do not reduce the consumed capacity.
+func (q *QueryBuilder) Filter(condition expression.ConditionBuilder) *QueryBuilder {
+	q.filters = append(q.filters, condition)
+	return q
+}
+
+// FilterEquals keeps the items whose attribute name equals value.
+func (q *QueryBuilder) FilterEquals(name string, value interface{}) *QueryBuilder {
+	return q.Filter(expression.Name(name).Equal(expression.Value(value)))
+}
+
+// FilterBetween keeps the items whose attribute name is between lower and upper, both included.
+func (q *QueryBuilder) FilterBetween(name string, lower interface{}, upper interface{}) *QueryBuilder {
+	return q.Filter(expression.Name(name).Between(expression.Value(lower), expression.Value(upper)))
+}
+
+// Project only returns the given attributes, which may be nested paths such as address.city.
+func (q *QueryBuilder) Project(names ...string) *QueryBuilder {
+	q.projection = append(q.projection, names...)
+	return q
+}
+
+// Descending returns the items in descending sort key order.
+func (q *QueryBuilder) Descending() *QueryBuilder {
+	q.descending = true
+	return q
+}
+
+// ConsistentRead reads with strong consistency, which global secondary indexes do not support.
+func (q *QueryBuilder) ConsistentRead() *QueryBuilder {
+	q.consistent = true
+	return q
+}
+
+// IncludeDeleted also returns the items marked as deleted by a repository using WithSoftDelete.
+func (q *QueryBuilder) IncludeDeleted() *QueryBuilder {
+	q.withDeleted = true
+	return q
+}
+
+// Limit sets the maximum number of items evaluated per page.
+// DynamoDB applies the limit before the filters, so a page can hold fewer items.
+func (q *QueryBuilder) Limit(limit int32) *QueryBuilder {
+	q.limit = limit
+	return q
+}
+
+// build build the query input for the given table, adding the soft delete filters unless deleted items are included.
+func (q *QueryBuilder) build(table string, notDeletedFilters ...expression.ConditionBuilder) (*dynamodb.QueryInput, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	filters := q.filters
+	if !q.withDeleted {
+		filters = append(append([]expression.ConditionBuilder{}, filters...), notDeletedFilters...)
+	}
+	builder := expression.NewBuilder().WithKeyCondition(q.keyCondition)
+	if filter, ok := joinConditions(filters); ok {
+		builder = builder.WithFilter(filter)
+	}
+	if len(q.projection) != 0 {
+		builder = builder.WithProjection(projectionOf(q.projection))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build query expression: %w", err)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	if len(q.indexName) != 0 {
+		input.IndexName = aws.String(q.indexName)
+	}
+	if q.descending {
+		input.ScanIndexForward = aws.Bool(false)
+	}
+	if q.consistent {
+		input.ConsistentRead = aws.Bool(true)
+	}
+	if q.limit > 0 {
+		input.Limit = aws.Int32(q.limit)
+	}
+	return input, nil
+}
+
+// projectionOf build a projection of the given attribute names, which must not be empty.
+func projectionOf(names []string) expression.ProjectionBuilder {
+	projection := expression.NamesList(expression.Name(names[0]))
+	for _, name := range names[1:] {
+		projection = projection.AddNames(expression.Name(name))
+	}
+	return projection
+}
+
+// QueryCore get one page of the items matching query starting at the given cursor.
+func (d DynamoDBRepository) QueryCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, cursor string) (*QueryPage, error) {
+	logs.LogTrackingInfo("QueryCore", ctx, request)
+	input, errorBuild := query.build(d.table, d.notDeletedFilters(false)...)
+	if errorBuild != nil {
+		logs.LogTrackingError("QueryCore", "build", ctx, request, errorBuild)
+		return nil, newOperationError("QueryCore", ErrValidation, errorBuild)
+	}
+	exclusiveStartKey, errorDecodeCursor := DecodeCursor(cursor, d.cursorSecret)
+	if errorDecodeCursor != nil {
+		logs.LogTrackingError("QueryCore", "DecodeCursor", ctx, request, errorDecodeCursor)
+		return nil, wrapError("QueryCore", errorDecodeCursor)
+	}
+	input.ExclusiveStartKey = exclusiveStartKey
+	logs.LogTrackingInfoData("QueryCore input", input, ctx, request)
+
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("QueryCore", "operationContext", ctx, request, errorTimeout)
+		return nil, wrapError("QueryCore", errorTimeout)
+	}
+	defer cancel()
+	response, err := d.client.GetItemByField(operationCtx, input)
+	if err != nil {
+		logs.LogTrackingError("QueryCore", "GetItemByField", ctx, request, err)
+		return nil, wrapError("QueryCore", err)
+	}
+
+	nextCursor, errorEncodeCursor := EncodeCursor(response.LastEvaluatedKey, d.cursorSecret)
+	if errorEncodeCursor != nil {
+		logs.LogTrackingError("QueryCore", "EncodeCursor", ctx, request, errorEncodeCursor)
+		return nil, wrapError("QueryCore", errorEncodeCursor)
+	}
+	return &QueryPage{
+		Items:      response.Items,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// QueryAllCore iterate over every page of the items matching query calling handlePage for each one.
+func (d DynamoDBRepository) QueryAllCore(ctx context.Context, request events.APIGatewayProxyRequest, query *QueryBuilder, handlePage func(items []map[string]types.AttributeValue) error) error {
+	logs.LogTrackingInfo("QueryAllCore", ctx, request)
+	cursor := ""
+	for {
+		page, err := d.QueryCore(ctx, request, query, cursor)
+		if err != nil {
+			return err
+		}
+		if errorHandlePage := handlePage(page.Items); errorHandlePage != nil {
+			logs.LogTrackingError("QueryAllCore", "handlePage", ctx, request, errorHandlePage)
+			return errorHandlePage
+		}
+		if len(page.NextCursor) == 0 {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,134 @@
+package dynamodbcore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/diegocabrera89/ms-payment-core/constantscore"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TableConfig declares a logical table of a Registry and how its name is resolved.
+type TableConfig struct {
+	// Name is the logical name used to get the repository, for example "merchants".
+	Name string
+	// Env is the environment variable holding the table name, the upper-cased Name with every character
+	// other than a letter or digit replaced by an underscore, followed by constantscore.TableNameEnvSuffix by default.
+	Env string
+	// Optional tables may be left unconfigured, otherwise NewRegistry fails.
+	Optional bool
+	// Options configure the repository of this table on top of the options of the registry.
+	// Client options have no effect here, since every repository uses the client of the registry.
+	Options []Option
+}
+
+// Registry hands out the repositories of the tables used by a service, sharing one AWS config and one client,
+// so that cold starts load them once whatever the number of tables.
+type Registry struct {
+	client       DynamoDBClientInterface
+	repositories map[string]*DynamoDBRepository
+	optional     map[string]bool
+}
+
+// NewRegistry resolves the table names from the environment, builds the shared client from the client options
+// in opts, and creates the repositories with opts followed by the options of each table.
+// It fails listing every required table whose environment variable is not set.
+func NewRegistry(region string, tables []TableConfig, opts ...Option) (*Registry, error) {
+	registry := &Registry{
+		repositories: make(map[string]*DynamoDBRepository, len(tables)),
+		optional:     make(map[string]bool),
+	}
+	tableNames := make(map[string]string, len(tables))
+	var missing []string
+	for _, table := range tables {
+		if len(table.Name) == 0 {
+			return nil, errors.New("logical table name is required")
+		}
+		if _, duplicated := tableNames[table.Name]; duplicated || registry.optional[table.Name] {
+			return nil, fmt.Errorf("table %s is registered twice", table.Name)
+		}
+		env := table.Env
+		if len(env) == 0 {
+			env = tableNameEnv(table.Name)
+		}
+		tableName := os.Getenv(env)
+		switch {
+		case len(tableName) != 0:
+			tableNames[table.Name] = tableName
+		case table.Optional:
+			registry.optional[table.Name] = true
+		default:
+			missing = append(missing, env)
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("tables not configured, set %s", strings.Join(missing, ", "))
+	}
+
+	shared := &DynamoDBRepository{}
+	for _, opt := range opts {
+		opt(shared)
+	}
+	registry.client = shared.client
+	if registry.client == nil {
+		client, err := shared.clientOptions.newClient(region)
+		if err != nil {
+			return nil, err
+		}
+		registry.client = client
+	}
+	if shared.clientOptions.resilient {
+		registry.client = NewResilientClient(registry.client, shared.clientOptions.resilience...)
+	}
+
+	for _, table := range tables {
+		tableName, configured := tableNames[table.Name]
+		if !configured {
+			continue
+		}
+		// The shared client is injected last, already wrapped, so that no repository builds or wraps its own.
+		repositoryOptions := append(append(append([]Option{}, opts...), table.Options...), WithClient(registry.client), withoutResilience())
+		repository, err := NewDynamoDBRepository(tableName, region, repositoryOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table.Name, err)
+		}
+		registry.repositories[table.Name] = repository
+	}
+	return registry, nil
+}
+
+// Repository returns the repository of a logical table.
+func (r *Registry) Repository(name string) (*DynamoDBRepository, error) {
+	if repository, found := r.repositories[name]; found {
+		return repository, nil
+	}
+	if r.optional[name] {
+		return nil, fmt.Errorf("optional table %s is not configured", name)
+	}
+	return nil, fmt.Errorf("table %s is not registered", name)
+}
+
+// Client returns the client shared by the repositories, for example to build a DynamoDBCheckpointStore.
+func (r *Registry) Client() DynamoDBClientInterface {
+	return r.client
+}
+
+// withoutResilience keeps a repository from wrapping its client again.
+func withoutResilience() Option {
+	return func(d *DynamoDBRepository) {
+		d.clientOptions.resilient = false
+	}
+}
+
+// tableNameEnv returns the default environment variable of a logical table, such as PAYMENT_EVENTS_TABLE for payment-events.
+func tableNameEnv(name string) string {
+	env := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.ToUpper(env) + constantscore.TableNameEnvSuffix
+}
@@ -0,0 +1,36 @@
+package dynamodbcore_test
+
+import (
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTableNameEnv(t *testing.T) {
+	t.Setenv("PAYMENT_EVENTS_TABLE", "payment-events-dev")
+	t.Setenv("MERCHANTS_V2_TABLE", "merchants-v2-dev")
+	client := dynamodbtest.NewClient()
+	registry, err := dynamodbcore.NewRegistry("us-east-1", []dynamodbcore.TableConfig{
+		{Name: "payment-events"},
+		{Name: "merchants.v2"},
+		{Name: "refund events", Optional: true},
+	}, dynamodbcore.WithClient(client), dynamodbcore.WithCursorSecret([]byte("test-secret")))
+	if err != nil {
+		t.Fatalf("NewRegistry error = %v", err)
+	}
+	for name, want := range map[string]string{"payment-events": "payment-events-dev", "merchants.v2": "merchants-v2-dev"} {
+		repository, err := registry.Repository(name)
+		if err != nil {
+			t.Fatalf("Repository(%s) error = %v", name, err)
+		}
+		if got := repository.TableName(); got != want {
+			t.Errorf("Repository(%s) table = %s, want %s", name, got, want)
+		}
+	}
+
+	_, err = dynamodbcore.NewRegistry("us-east-1", []dynamodbcore.TableConfig{{Name: "refund events"}}, dynamodbcore.WithClient(client))
+	if err == nil || !strings.Contains(err.Error(), "REFUND_EVENTS_TABLE") {
+		t.Errorf("NewRegistry error = %v, want it to name REFUND_EVENTS_TABLE", err)
+	}
+}
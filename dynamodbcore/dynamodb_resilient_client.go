@@ -0,0 +1,488 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of a table.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every call with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe call through to decide whether to close or open again.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// RetryPolicy defines how calls failing with throttling or server errors are retried.
+// MaxAttempts counts the first call, so 1 disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// CircuitBreakerPolicy defines when the circuit breaker of a table opens.
+// It opens after FailureThreshold consecutive failed calls and lets a probe through after OpenDuration.
+// A FailureThreshold of zero disables the circuit breaker.
+type CircuitBreakerPolicy struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// defaultRetryPolicy is used when no RetryPolicy is configured.
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// defaultCircuitBreakerPolicy is used when no CircuitBreakerPolicy is configured.
+var defaultCircuitBreakerPolicy = CircuitBreakerPolicy{
+	FailureThreshold: 5,
+	OpenDuration:     10 * time.Second,
+}
+
+// ResilientClient decorates a DynamoDBClientInterface with retries and a circuit breaker per table.
+// Throttling and server errors are retried with exponential backoff and jitter; other errors are returned as is.
+// Throttling, server errors, timeouts and transport errors count as failures of the circuit breaker, other
+// answers of DynamoDB as successes, and calls canceled by the caller are not counted at all.
+// The client wrapped should not retry itself, see aws.NopRetryer, or every attempt is retried twice.
+type ResilientClient struct {
+	client        DynamoDBClientInterface
+	retryPolicy   RetryPolicy
+	breakerPolicy CircuitBreakerPolicy
+	onStateChange func(table string, from CircuitState, to CircuitState)
+	onRetry       func(operation string, table string, attempt int, err error)
+	now           func() time.Time
+
+	mutex    sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// circuitBreaker holds the state of the circuit breaker of a table.
+// generation changes with every state change so outcomes of calls admitted in an earlier state are ignored.
+type circuitBreaker struct {
+	state      CircuitState
+	failures   int
+	openedAt   time.Time
+	probing    bool
+	generation uint64
+}
+
+// admission records the state of the circuit breaker of a table when a call was let through.
+type admission struct {
+	table      string
+	generation uint64
+	probe      bool
+}
+
+// stateChange records a transition to report once the lock is released.
+type stateChange struct {
+	table string
+	from  CircuitState
+	to    CircuitState
+}
+
+// ResilientOption configures a ResilientClient.
+type ResilientOption func(*ResilientClient)
+
+// WithRetryPolicy sets how throttling and server errors are retried.
+// A MaxAttempts below 1 is taken as 1, backoffs that are not positive take the default, and MaxBackoff
+// is raised to at least InitialBackoff.
+func WithRetryPolicy(policy RetryPolicy) ResilientOption {
+	return func(c *ResilientClient) {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		if policy.InitialBackoff <= 0 {
+			policy.InitialBackoff = defaultRetryPolicy.InitialBackoff
+		}
+		if policy.MaxBackoff <= 0 {
+			policy.MaxBackoff = defaultRetryPolicy.MaxBackoff
+		}
+		if policy.MaxBackoff < policy.InitialBackoff {
+			policy.MaxBackoff = policy.InitialBackoff
+		}
+		c.retryPolicy = policy
+	}
+}
+
+// WithCircuitBreakerPolicy sets when the circuit breaker of a table opens.
+func WithCircuitBreakerPolicy(policy CircuitBreakerPolicy) ResilientOption {
+	return func(c *ResilientClient) {
+		c.breakerPolicy = policy
+	}
+}
+
+// WithStateChangeHook calls hook every time the circuit breaker of a table changes state.
+func WithStateChangeHook(hook func(table string, from CircuitState, to CircuitState)) ResilientOption {
+	return func(c *ResilientClient) {
+		c.onStateChange = hook
+	}
+}
+
+// WithRetryHook calls hook before every retry with the attempt that failed and its error.
+func WithRetryHook(hook func(operation string, table string, attempt int, err error)) ResilientOption {
+	return func(c *ResilientClient) {
+		c.onRetry = hook
+	}
+}
+
+// NewResilientClient wraps client with retries and circuit breakers.
+func NewResilientClient(client DynamoDBClientInterface, opts ...ResilientOption) *ResilientClient {
+	resilientClient := &ResilientClient{
+		client:        client,
+		retryPolicy:   defaultRetryPolicy,
+		breakerPolicy: defaultCircuitBreakerPolicy,
+		now:           time.Now,
+		breakers:      make(map[string]*circuitBreaker),
+	}
+	for _, opt := range opts {
+		opt(resilientClient)
+	}
+	return resilientClient
+}
+
+// State returns the current state of the circuit breaker of a table.
+func (c *ResilientClient) State(table string) CircuitState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if breaker, found := c.breakers[table]; found {
+		return breaker.state
+	}
+	return CircuitClosed
+}
+
+// PutItem implements DynamoDB's PutItem operation.
+func (c *ResilientClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return invokeResilient(ctx, c, "PutItem", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.PutItemOutput, error) {
+		return c.client.PutItem(ctx, params, optFns...)
+	})
+}
+
+// GetItem implements DynamoDB's GetItem operation.
+func (c *ResilientClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return invokeResilient(ctx, c, "GetItem", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.GetItemOutput, error) {
+		return c.client.GetItem(ctx, params, optFns...)
+	})
+}
+
+// DeleteItem implements DynamoDB's DeleteItem operation.
+func (c *ResilientClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return invokeResilient(ctx, c, "DeleteItem", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.DeleteItemOutput, error) {
+		return c.client.DeleteItem(ctx, params, optFns...)
+	})
+}
+
+// UpdateItem implements DynamoDB's UpdateItem operation.
+func (c *ResilientClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return invokeResilient(ctx, c, "UpdateItem", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.UpdateItemOutput, error) {
+		return c.client.UpdateItem(ctx, params, optFns...)
+	})
+}
+
+// GetItemByField implements DynamoDB's Query operation.
+func (c *ResilientClient) GetItemByField(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return invokeResilient(ctx, c, "Query", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.QueryOutput, error) {
+		return c.client.GetItemByField(ctx, params, optFns...)
+	})
+}
+
+// TransactWriteItems implements DynamoDB's TransactWriteItems operation.
+func (c *ResilientClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	var tables []string
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Put != nil:
+			tables = append(tables, aws.ToString(item.Put.TableName))
+		case item.Update != nil:
+			tables = append(tables, aws.ToString(item.Update.TableName))
+		case item.Delete != nil:
+			tables = append(tables, aws.ToString(item.Delete.TableName))
+		case item.ConditionCheck != nil:
+			tables = append(tables, aws.ToString(item.ConditionCheck.TableName))
+		}
+	}
+	return invokeResilient(ctx, c, "TransactWriteItems", tables, func(ctx context.Context) (*dynamodb.TransactWriteItemsOutput, error) {
+		return c.client.TransactWriteItems(ctx, params, optFns...)
+	})
+}
+
+// TransactGetItems implements DynamoDB's TransactGetItems operation.
+func (c *ResilientClient) TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error) {
+	var tables []string
+	for _, item := range params.TransactItems {
+		if item.Get != nil {
+			tables = append(tables, aws.ToString(item.Get.TableName))
+		}
+	}
+	return invokeResilient(ctx, c, "TransactGetItems", tables, func(ctx context.Context) (*dynamodb.TransactGetItemsOutput, error) {
+		return c.client.TransactGetItems(ctx, params, optFns...)
+	})
+}
+
+// BatchGetItem implements DynamoDB's BatchGetItem operation.
+func (c *ResilientClient) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	var tables []string
+	for table := range params.RequestItems {
+		tables = append(tables, table)
+	}
+	return invokeResilient(ctx, c, "BatchGetItem", tables, func(ctx context.Context) (*dynamodb.BatchGetItemOutput, error) {
+		return c.client.BatchGetItem(ctx, params, optFns...)
+	})
+}
+
+// BatchWriteItem implements DynamoDB's BatchWriteItem operation.
+func (c *ResilientClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	var tables []string
+	for table := range params.RequestItems {
+		tables = append(tables, table)
+	}
+	return invokeResilient(ctx, c, "BatchWriteItem", tables, func(ctx context.Context) (*dynamodb.BatchWriteItemOutput, error) {
+		return c.client.BatchWriteItem(ctx, params, optFns...)
+	})
+}
+
+// Scan implements DynamoDB's Scan operation.
+func (c *ResilientClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return invokeResilient(ctx, c, "Scan", []string{aws.ToString(params.TableName)}, func(ctx context.Context) (*dynamodb.ScanOutput, error) {
+		return c.client.Scan(ctx, params, optFns...)
+	})
+}
+
+// invokeResilient calls the client through the circuit breakers of the given tables, retrying retryable errors.
+func invokeResilient[T any](ctx context.Context, c *ResilientClient, operation string, tables []string, call func(ctx context.Context) (T, error)) (T, error) {
+	tables = uniqueTables(tables)
+	var zero T
+	admissions, errorAcquire := c.acquire(tables)
+	if errorAcquire != nil {
+		return zero, errorAcquire
+	}
+
+	backoff := c.retryPolicy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		output, err := call(ctx)
+		if err == nil || !isRetryableError(err) || attempt >= c.retryPolicy.MaxAttempts || ctx.Err() != nil {
+			c.settle(ctx, admissions, err)
+			return output, err
+		}
+
+		wait := jitteredBackoff(backoff)
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Now().Add(wait).After(deadline) {
+			c.settle(ctx, admissions, err)
+			return output, err
+		}
+		if c.onRetry != nil {
+			c.onRetry(operation, strings.Join(tables, ","), attempt, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.settle(ctx, admissions, err)
+			return output, err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > c.retryPolicy.MaxBackoff {
+			backoff = c.retryPolicy.MaxBackoff
+		}
+	}
+}
+
+// settle records the outcome of a call in the circuit breakers of the tables. Calls canceled by the caller
+// say nothing about the table and only release the probe.
+func (c *ResilientClient) settle(ctx context.Context, admissions []admission, err error) {
+	switch {
+	case err == nil:
+		c.release(admissions, true)
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		c.abandon(admissions)
+	default:
+		c.release(admissions, !isFailureError(err))
+	}
+}
+
+// acquire checks the circuit breakers of the tables, moving expired open breakers to half-open, and returns
+// the state each breaker let the call through in.
+func (c *ResilientClient) acquire(tables []string) ([]admission, error) {
+	if c.breakerPolicy.FailureThreshold <= 0 {
+		return nil, nil
+	}
+	var changes []stateChange
+	c.mutex.Lock()
+	now := c.now()
+	var errorOpen error
+	for _, table := range tables {
+		breaker := c.breaker(table)
+		switch breaker.state {
+		case CircuitOpen:
+			if now.Sub(breaker.openedAt) < c.breakerPolicy.OpenDuration {
+				errorOpen = fmt.Errorf("%w for table %s", ErrCircuitOpen, table)
+			}
+		case CircuitHalfOpen:
+			if breaker.probing {
+				errorOpen = fmt.Errorf("%w for table %s", ErrCircuitOpen, table)
+			}
+		}
+	}
+	var admissions []admission
+	if errorOpen == nil {
+		for _, table := range tables {
+			breaker := c.breaker(table)
+			if breaker.state == CircuitOpen {
+				changes = append(changes, breaker.transition(table, CircuitHalfOpen))
+			}
+			if breaker.state == CircuitHalfOpen {
+				breaker.probing = true
+			}
+			admissions = append(admissions, admission{table: table, generation: breaker.generation, probe: breaker.state == CircuitHalfOpen})
+		}
+	}
+	c.mutex.Unlock()
+	c.notify(changes)
+	return admissions, errorOpen
+}
+
+// release records the outcome of a call in the circuit breakers of the tables. Only the probe closes or
+// opens again a half-open breaker, and outcomes of calls admitted before the last state change are ignored.
+func (c *ResilientClient) release(admissions []admission, success bool) {
+	var changes []stateChange
+	c.mutex.Lock()
+	for _, admitted := range admissions {
+		breaker := c.breaker(admitted.table)
+		if breaker.generation != admitted.generation {
+			continue
+		}
+		switch {
+		case admitted.probe && success:
+			breaker.probing = false
+			changes = append(changes, breaker.transition(admitted.table, CircuitClosed))
+		case admitted.probe:
+			breaker.probing = false
+			breaker.openedAt = c.now()
+			changes = append(changes, breaker.transition(admitted.table, CircuitOpen))
+		case success:
+			breaker.failures = 0
+		default:
+			breaker.failures++
+			if breaker.failures >= c.breakerPolicy.FailureThreshold {
+				breaker.openedAt = c.now()
+				changes = append(changes, breaker.transition(admitted.table, CircuitOpen))
+			}
+		}
+	}
+	c.mutex.Unlock()
+	c.notify(changes)
+}
+
+// abandon releases the probe of half-open breakers when the caller gives up, without recording an outcome.
+func (c *ResilientClient) abandon(admissions []admission) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, admitted := range admissions {
+		breaker := c.breaker(admitted.table)
+		if admitted.probe && breaker.generation == admitted.generation {
+			breaker.probing = false
+		}
+	}
+}
+
+// transition moves the breaker to a new state, resetting its failures. The caller must hold the lock.
+func (b *circuitBreaker) transition(table string, to CircuitState) stateChange {
+	change := stateChange{table: table, from: b.state, to: to}
+	b.state = to
+	b.failures = 0
+	b.generation++
+	return change
+}
+
+// breaker returns the circuit breaker of a table, creating it closed. The caller must hold the lock.
+func (c *ResilientClient) breaker(table string) *circuitBreaker {
+	breaker, found := c.breakers[table]
+	if !found {
+		breaker = &circuitBreaker{state: CircuitClosed}
+		c.breakers[table] = breaker
+	}
+	return breaker
+}
+
+// notify reports state changes to the hook.
+func (c *ResilientClient) notify(changes []stateChange) {
+	if c.onStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		c.onStateChange(change.table, change.from, change.to)
+	}
+}
+
+// isRetryableError reports whether an error is caused by throttling or by a server error.
+func isRetryableError(err error) bool {
+	if classifyError(err) == ErrThrottled {
+		return true
+	}
+	var statusError interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusError) && statusError.HTTPStatusCode() >= 500 {
+		return true
+	}
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		switch apiError.ErrorCode() {
+		case "InternalServerError", "ServiceUnavailable":
+			return true
+		}
+		return apiError.ErrorFault() == smithy.FaultServer
+	}
+	return false
+}
+
+// isFailureError reports whether an error means the table is unhealthy or unreachable: throttling, server errors,
+// timeouts and transport errors, which never got an answer from DynamoDB.
+func isFailureError(err error) bool {
+	if isRetryableError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiError smithy.APIError
+	return !errors.As(err, &apiError)
+}
+
+// uniqueTables returns the sorted distinct table names.
+func uniqueTables(tables []string) []string {
+	seen := make(map[string]bool, len(tables))
+	unique := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if !seen[table] {
+			seen[table] = true
+			unique = append(unique, table)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,253 @@
+package dynamodbcore_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stateRecorder struct {
+	mutex   sync.Mutex
+	changes []string
+}
+
+func (r *stateRecorder) record(table string, from dynamodbcore.CircuitState, to dynamodbcore.CircuitState) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.changes = append(r.changes, table+": "+from.String()+" -> "+to.String())
+}
+
+func (r *stateRecorder) recorded() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.changes...)
+}
+
+func newResilientTestClient(recorder *stateRecorder) (*dynamodbcore.ResilientClient, *dynamodbtest.Client) {
+	client := dynamodbtest.NewClient(dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"})
+	resilientClient := dynamodbcore.NewResilientClient(client,
+		dynamodbcore.WithRetryPolicy(dynamodbcore.RetryPolicy{MaxAttempts: 1}),
+		dynamodbcore.WithCircuitBreakerPolicy(dynamodbcore.CircuitBreakerPolicy{FailureThreshold: 2, OpenDuration: 20 * time.Millisecond}),
+		dynamodbcore.WithStateChangeHook(recorder.record),
+	)
+	return resilientClient, client
+}
+
+func getPayment(ctx context.Context, client *dynamodbcore.ResilientClient) error {
+	_, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("payments"),
+		Key:       map[string]types.AttributeValue{"id": dynamodbcore.StringValue("p1")},
+	})
+	return err
+}
+
+func TestResilientClientFailureClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		latency   time.Duration
+		cancel    bool
+		wantState dynamodbcore.CircuitState
+	}{
+		{name: "success", wantState: dynamodbcore.CircuitClosed},
+		{name: "throttling", err: &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}, wantState: dynamodbcore.CircuitOpen},
+		{name: "server error", err: &types.InternalServerError{Message: aws.String("internal")}, wantState: dynamodbcore.CircuitOpen},
+		{name: "transport error", err: errors.New("connection reset by peer"), wantState: dynamodbcore.CircuitOpen},
+		{name: "timeout", latency: time.Second, wantState: dynamodbcore.CircuitOpen},
+		{name: "client error", err: &smithy.GenericAPIError{Code: "ValidationException", Message: "invalid", Fault: smithy.FaultClient}, wantState: dynamodbcore.CircuitClosed},
+		{name: "condition failure", err: &types.ConditionalCheckFailedException{Message: aws.String("failed")}, wantState: dynamodbcore.CircuitClosed},
+		{name: "caller cancellation", latency: time.Second, cancel: true, wantState: dynamodbcore.CircuitClosed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resilientClient, client := newResilientTestClient(&stateRecorder{})
+			client.Latency = test.latency
+			client.ErrorHook = func(string, string) error { return test.err }
+			for call := 0; call < 2; call++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				if test.cancel {
+					go func() {
+						time.Sleep(time.Millisecond)
+						cancel()
+					}()
+				}
+				_ = getPayment(ctx, resilientClient)
+				cancel()
+			}
+			if got := resilientClient.State("payments"); got != test.wantState {
+				t.Errorf("State = %s, want %s", got, test.wantState)
+			}
+		})
+	}
+}
+
+func TestResilientClientStateChanges(t *testing.T) {
+	recorder := &stateRecorder{}
+	resilientClient, client := newResilientTestClient(recorder)
+	var failing error = &types.InternalServerError{Message: aws.String("internal")}
+	client.ErrorHook = func(string, string) error { return failing }
+	ctx := context.Background()
+
+	for call := 0; call < 2; call++ {
+		_ = getPayment(ctx, resilientClient)
+	}
+	if err := getPayment(ctx, resilientClient); !errors.Is(err, dynamodbcore.ErrCircuitOpen) {
+		t.Fatalf("GetItem on an open circuit error = %v, want %v", err, dynamodbcore.ErrCircuitOpen)
+	}
+
+	// A failed probe opens the circuit again.
+	time.Sleep(30 * time.Millisecond)
+	_ = getPayment(ctx, resilientClient)
+	if got := resilientClient.State("payments"); got != dynamodbcore.CircuitOpen {
+		t.Fatalf("State after a failed probe = %s, want %s", got, dynamodbcore.CircuitOpen)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(30 * time.Millisecond)
+	failing = nil
+	if err := getPayment(ctx, resilientClient); err != nil {
+		t.Fatalf("GetItem probe error = %v", err)
+	}
+
+	want := []string{
+		"payments: closed -> open",
+		"payments: open -> half-open",
+		"payments: half-open -> open",
+		"payments: open -> half-open",
+		"payments: half-open -> closed",
+	}
+	if got := recorder.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("state changes = %v, want %v", got, want)
+	}
+}
+
+// blockingClient holds GetItem calls for item "slow" until release is closed.
+type blockingClient struct {
+	*dynamodbtest.Client
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if id, _ := params.Key["id"].(*types.AttributeValueMemberS); id != nil && id.Value == "slow" {
+		close(c.started)
+		<-c.release
+	}
+	return c.Client.GetItem(ctx, params, optFns...)
+}
+
+func TestResilientClientIgnoresStaleOutcomes(t *testing.T) {
+	client := &blockingClient{
+		Client:  dynamodbtest.NewClient(dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"}),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	recorder := &stateRecorder{}
+	resilientClient := dynamodbcore.NewResilientClient(client,
+		dynamodbcore.WithRetryPolicy(dynamodbcore.RetryPolicy{MaxAttempts: 1}),
+		dynamodbcore.WithCircuitBreakerPolicy(dynamodbcore.CircuitBreakerPolicy{FailureThreshold: 2, OpenDuration: time.Hour}),
+		dynamodbcore.WithStateChangeHook(recorder.record),
+	)
+	ctx := context.Background()
+
+	// A call admitted while the circuit is closed succeeds only after other calls opened it.
+	slow := make(chan error)
+	go func() {
+		_, err := resilientClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String("payments"),
+			Key:       map[string]types.AttributeValue{"id": dynamodbcore.StringValue("slow")},
+		})
+		slow <- err
+	}()
+	<-client.started
+	client.ErrorHook = func(string, string) error { return &types.InternalServerError{Message: aws.String("internal")} }
+	for call := 0; call < 2; call++ {
+		_ = getPayment(ctx, resilientClient)
+	}
+	client.ErrorHook = nil
+	close(client.release)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow GetItem error = %v", err)
+	}
+
+	if got := resilientClient.State("payments"); got != dynamodbcore.CircuitOpen {
+		t.Errorf("State after a stale success = %s, want %s", got, dynamodbcore.CircuitOpen)
+	}
+	if want := []string{"payments: closed -> open"}; !reflect.DeepEqual(recorder.recorded(), want) {
+		t.Errorf("state changes = %v, want %v", recorder.recorded(), want)
+	}
+}
+
+func TestResilientClientRetries(t *testing.T) {
+	client := dynamodbtest.NewClient(dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"})
+	calls := 0
+	client.ErrorHook = func(string, string) error {
+		calls++
+		if calls < 3 {
+			return &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+		}
+		return nil
+	}
+	var retries []int
+	resilientClient := dynamodbcore.NewResilientClient(client,
+		dynamodbcore.WithRetryPolicy(dynamodbcore.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		dynamodbcore.WithRetryHook(func(operation string, table string, attempt int, err error) {
+			retries = append(retries, attempt)
+		}),
+	)
+	if err := getPayment(context.Background(), resilientClient); err != nil {
+		t.Fatalf("GetItem error = %v", err)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(retries, want) {
+		t.Errorf("retried attempts = %v, want %v", retries, want)
+	}
+	if got := resilientClient.State("payments"); got != dynamodbcore.CircuitClosed {
+		t.Errorf("State = %s, want %s", got, dynamodbcore.CircuitClosed)
+	}
+}
+
+func TestResilientClientRetryPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      dynamodbcore.RetryPolicy
+		wantRetries int
+		minElapsed  time.Duration
+	}{
+		{name: "zero value", policy: dynamodbcore.RetryPolicy{}, wantRetries: 0},
+		{name: "zero backoffs", policy: dynamodbcore.RetryPolicy{MaxAttempts: 2}, wantRetries: 1, minElapsed: 25 * time.Millisecond},
+		{name: "max backoff below initial backoff", policy: dynamodbcore.RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond, MaxBackoff: time.Nanosecond}, wantRetries: 2, minElapsed: 20 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := dynamodbtest.NewClient(dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"})
+			client.ErrorHook = func(string, string) error {
+				return &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+			}
+			retries := 0
+			resilientClient := dynamodbcore.NewResilientClient(client,
+				dynamodbcore.WithRetryPolicy(test.policy),
+				dynamodbcore.WithRetryHook(func(string, string, int, error) { retries++ }),
+			)
+			started := time.Now()
+			var throttled *types.ProvisionedThroughputExceededException
+			if err := getPayment(context.Background(), resilientClient); !errors.As(err, &throttled) {
+				t.Fatalf("GetItem error = %v, want a throttling error", err)
+			}
+			if retries != test.wantRetries {
+				t.Errorf("retries = %d, want %d", retries, test.wantRetries)
+			}
+			if elapsed := time.Since(started); elapsed < test.minElapsed {
+				t.Errorf("retries took %s, want at least %s", elapsed, test.minElapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+)
+
+// ScanOption configures a single ScanCore call.
+type ScanOption func(*scanOptions)
+
+// scanOptions holds the per-call settings of ScanCore.
+type scanOptions struct {
+	segments    int32
+	concurrency int
+	pageSize    int32
+	indexName   string
+	filter      *expression.ConditionBuilder
+	projection  []string
+	checkpoints CheckpointStore
+	scanID      string
+	withDeleted bool
+}
+
+// WithSegments splits the table in the given number of segments scanned in parallel.
+func WithSegments(segments int32) ScanOption {
+	return func(o *scanOptions) {
+		if segments > 0 {
+			o.segments = segments
+		}
+	}
+}
+
+// WithScanConcurrency sets how many segments are scanned at the same time, every segment by default.
+func WithScanConcurrency(concurrency int) ScanOption {
+	return func(o *scanOptions) {
+		if concurrency > 0 {
+			o.concurrency = concurrency
+		}
+	}
+}
+
+// WithScanPageSize sets the maximum number of items evaluated per page.
+func WithScanPageSize(pageSize int32) ScanOption {
+	return func(o *scanOptions) {
+		o.pageSize = pageSize
+	}
+}
+
+// WithScanIndex scans the given index instead of the table.
+func WithScanIndex(indexName string) ScanOption {
+	return func(o *scanOptions) {
+		o.indexName = indexName
+	}
+}
+
+// WithScanFilter only hands the items matching condition to the callback.
+func WithScanFilter(condition expression.ConditionBuilder) ScanOption {
+	return func(o *scanOptions) {
+		o.filter = &condition
+	}
+}
+
+// WithScanProjection only reads the given attributes.
+func WithScanProjection(names ...string) ScanOption {
+	return func(o *scanOptions) {
+		o.projection = append(o.projection, names...)
+	}
+}
+
+// WithCheckpoint saves the progress of every segment in store under scanID after each page,
+// so a scan started again with the same scanID and segments resumes where it stopped.
+func WithCheckpoint(store CheckpointStore, scanID string) ScanOption {
+	return func(o *scanOptions) {
+		o.checkpoints = store
+		o.scanID = scanID
+	}
+}
+
+// WithScanIncludeDeleted also hands the items marked as deleted to the callback.
+func WithScanIncludeDeleted() ScanOption {
+	return func(o *scanOptions) {
+		o.withDeleted = true
+	}
+}
+
+// ScanCore read every item of the table calling handleItems for each page of each segment.
+// Segments are scanned in parallel and handleItems must be safe for concurrent use.
+// The scan stops at the first error; with WithCheckpoint the pages already handled are not read again.
+func (d DynamoDBRepository) ScanCore(ctx context.Context, request events.APIGatewayProxyRequest, handleItems func(ctx context.Context, segment int32, items []map[string]types.AttributeValue) error, opts ...ScanOption) error {
+	logs.LogTrackingInfo("ScanCore", ctx, request)
+	options := scanOptions{segments: 1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.checkpoints != nil && len(options.scanID) == 0 {
+		errorScanID := errors.New("scan ID is required to save checkpoints")
+		logs.LogTrackingError("ScanCore", "WithCheckpoint", ctx, request, errorScanID)
+		return newOperationError("ScanCore", ErrValidation, errorScanID)
+	}
+	input, errorBuild := buildScanInput(d.table, options, d.notDeletedFilters(options.withDeleted))
+	if errorBuild != nil {
+		logs.LogTrackingError("ScanCore", "buildScanInput", ctx, request, errorBuild)
+		return newOperationError("ScanCore", ErrValidation, errorBuild)
+	}
+	logs.LogTrackingInfoData("ScanCore input", input, ctx, request)
+
+	concurrency := options.concurrency
+	if concurrency <= 0 {
+		concurrency = int(options.segments)
+	}
+	err := runConcurrently(ctx, concurrency, int(options.segments), func(ctx context.Context, task int) error {
+		return d.scanSegment(ctx, *input, int32(task), options, handleItems)
+	})
+	if err != nil {
+		logs.LogTrackingError("ScanCore", "scanSegment", ctx, request, err)
+		return wrapError("ScanCore", err)
+	}
+	return nil
+}
+
+// scanSegment reads every page of a segment, resuming from and saving its checkpoint when configured.
+func (d DynamoDBRepository) scanSegment(ctx context.Context, input dynamodb.ScanInput, segment int32, options scanOptions, handleItems func(ctx context.Context, segment int32, items []map[string]types.AttributeValue) error) error {
+	if options.segments > 1 {
+		input.Segment = aws.Int32(segment)
+		input.TotalSegments = aws.Int32(options.segments)
+	}
+	if options.checkpoints != nil {
+		checkpoint, found, err := options.checkpoints.LoadCheckpoint(ctx, options.scanID, segment)
+		if err != nil {
+			return fmt.Errorf("load checkpoint of segment %d: %w", segment, err)
+		}
+		if found {
+			if checkpoint.TotalSegments != options.segments {
+				return newOperationError("ScanCore", ErrValidation,
+					fmt.Errorf("checkpoint of scan %s was saved with %d segments, not %d", options.scanID, checkpoint.TotalSegments, options.segments))
+			}
+			if checkpoint.Done {
+				return nil
+			}
+			exclusiveStartKey, errorDecodeCursor := DecodeCursor(checkpoint.Cursor, d.cursorSecret)
+			if errorDecodeCursor != nil {
+				return errorDecodeCursor
+			}
+			input.ExclusiveStartKey = exclusiveStartKey
+		}
+	}
+
+	for {
+		response, err := d.scanPage(ctx, &input)
+		if err != nil {
+			return err
+		}
+		if errorHandleItems := handleItems(ctx, segment, response.Items); errorHandleItems != nil {
+			return errorHandleItems
+		}
+		if options.checkpoints != nil {
+			cursor, errorEncodeCursor := EncodeCursor(response.LastEvaluatedKey, d.cursorSecret)
+			if errorEncodeCursor != nil {
+				return errorEncodeCursor
+			}
+			checkpoint := ScanCheckpoint{
+				Segment:       segment,
+				TotalSegments: options.segments,
+				Cursor:        cursor,
+				Done:          len(response.LastEvaluatedKey) == 0,
+			}
+			if errorSave := options.checkpoints.SaveCheckpoint(ctx, options.scanID, checkpoint); errorSave != nil {
+				return fmt.Errorf("save checkpoint of segment %d: %w", segment, errorSave)
+			}
+		}
+		if len(response.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = response.LastEvaluatedKey
+	}
+}
+
+// scanPage reads a single page within the time budget of one DynamoDB call.
+func (d DynamoDBRepository) scanPage(ctx context.Context, input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		return nil, errorTimeout
+	}
+	defer cancel()
+	return d.client.Scan(operationCtx, input)
+}
+
+// buildScanInput build the scan input shared by every segment.
+func buildScanInput(table string, options scanOptions, extraFilters []expression.ConditionBuilder) (*dynamodb.ScanInput, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}
+	if len(options.indexName) != 0 {
+		input.IndexName = aws.String(options.indexName)
+	}
+	if options.pageSize > 0 {
+		input.Limit = aws.Int32(options.pageSize)
+	}
+	filters := extraFilters
+	if options.filter != nil {
+		filters = append([]expression.ConditionBuilder{*options.filter}, filters...)
+	}
+	if len(filters) == 0 && len(options.projection) == 0 {
+		return input, nil
+	}
+
+	builder := expression.NewBuilder()
+	if filter, ok := joinConditions(filters); ok {
+		builder = builder.WithFilter(filter)
+	}
+	if len(options.projection) != 0 {
+		builder = builder.WithProjection(projectionOf(options.projection))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build scan expression: %w", err)
+	}
+	input.FilterExpression = expr.Filter()
+	input.ProjectionExpression = expr.Projection()
+	input.ExpressionAttributeNames = expr.Names()
+	input.ExpressionAttributeValues = expr.Values()
+	return input, nil
+}
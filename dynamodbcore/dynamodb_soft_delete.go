@@ -0,0 +1,136 @@
+package dynamodbcore
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"time"
+)
+
+// defaultDeletedAtAttribute is the attribute holding the deletion time when none is configured.
+const defaultDeletedAtAttribute = "deletedAt"
+
+// SoftDeletePolicy defines how DeleteItemCore marks items as deleted instead of removing them.
+// DeletedAtAttribute receives the deletion time in RFC 3339 and, when StatusAttribute is set,
+// it receives DeletedStatus.
+type SoftDeletePolicy struct {
+	DeletedAtAttribute string
+	StatusAttribute    string
+	DeletedStatus      string
+}
+
+// WithSoftDelete makes DeleteItemCore mark items as deleted, and makes reads, queries and scans skip them.
+// The key of a soft-deleted item stays taken: creating an item at it fails with ErrAlreadyExists even though
+// reads report ErrNotFound, until the item is removed with WithHardDelete. Deletes of BatchWriteCore
+// cannot be soft deletes, so they fail with ErrValidation.
+func WithSoftDelete(policy SoftDeletePolicy) Option {
+	return func(d *DynamoDBRepository) {
+		if len(policy.DeletedAtAttribute) == 0 {
+			policy.DeletedAtAttribute = defaultDeletedAtAttribute
+		}
+		d.softDelete = &policy
+	}
+}
+
+// DeleteOption configures a single DeleteItemCore call.
+type DeleteOption func(*deleteOptions)
+
+// deleteOptions holds the per-call settings of DeleteItemCore.
+type deleteOptions struct {
+	hard bool
+}
+
+// WithHardDelete removes the item even when soft delete is enabled.
+func WithHardDelete() DeleteOption {
+	return func(o *deleteOptions) {
+		o.hard = true
+	}
+}
+
+// WithIncludeDeleted also returns the items marked as deleted.
+func WithIncludeDeleted() ReadOption {
+	return func(o *readOptions) {
+		o.includeDeleted = true
+	}
+}
+
+// softDeleteItem marks the item stored at key as deleted, failing with ErrNotFound when there is none.
+func (d DynamoDBRepository) softDeleteItem(ctx context.Context, request events.APIGatewayProxyRequest, key Key) error {
+	change := historyChange{operation: HistoryOperationDelete, key: key}
+	deletedAt := expression.Name(d.softDelete.DeletedAtAttribute)
+	now := time.Now().UTC().Format(time.RFC3339)
+	update := expression.Set(deletedAt, expression.Value(now))
+	change.updates = append(change.updates, historyUpdate{path: d.softDelete.DeletedAtAttribute, value: StringValue(now)})
+	if len(d.softDelete.StatusAttribute) != 0 {
+		update = update.Set(expression.Name(d.softDelete.StatusAttribute), expression.Value(d.softDelete.DeletedStatus))
+		change.updates = append(change.updates, historyUpdate{path: d.softDelete.StatusAttribute, value: StringValue(d.softDelete.DeletedStatus)})
+	}
+	if len(d.versionAttribute) != 0 {
+		update = update.Add(expression.Name(d.versionAttribute), expression.Value(1))
+		change.updates = append(change.updates, historyUpdate{path: d.versionAttribute, operation: helpers.UpdateOperationAdd, value: IntValue(1)})
+	}
+	if d.audit != nil {
+		update = d.stampUpdate(update, request, &change)
+	}
+	condition := expression.And(key.existsCondition(), expression.AttributeNotExists(deletedAt))
+	expr, errorExpression := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if errorExpression != nil {
+		logs.LogTrackingError("DeleteItemCore", "expression.NewBuilder", ctx, request, errorExpression)
+		return newOperationError("DeleteItemCore", ErrValidation, errorExpression)
+	}
+	input := &dynamodb.UpdateItemInput{
+		Key:                       key.AttributeMap(),
+		TableName:                 aws.String(d.table),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+	}
+	logs.LogTrackingInfoData("DeleteItemCore input", input, ctx, request)
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("DeleteItemCore", "operationContext", ctx, request, errorTimeout)
+		return wrapError("DeleteItemCore", errorTimeout)
+	}
+	defer cancel()
+	_, err := d.updateItem(operationCtx, request, input, change)
+	if err != nil {
+		logs.LogTrackingError("DeleteItemCore", "UpdateItem", ctx, request, err)
+		if classifyError(err) == ErrConditionFailed {
+			return newOperationError("DeleteItemCore", ErrNotFound, err)
+		}
+		return wrapError("DeleteItemCore", err)
+	}
+	return nil
+}
+
+// notDeletedFilters returns the filter excluding soft-deleted items, if soft delete is enabled.
+func (d DynamoDBRepository) notDeletedFilters(includeDeleted bool) []expression.ConditionBuilder {
+	if d.softDelete == nil || includeDeleted {
+		return nil
+	}
+	return []expression.ConditionBuilder{expression.AttributeNotExists(expression.Name(d.softDelete.DeletedAtAttribute))}
+}
+
+// isSoftDeleted reports whether an item is marked as deleted.
+func (d DynamoDBRepository) isSoftDeleted(item map[string]types.AttributeValue) bool {
+	if d.softDelete == nil {
+		return false
+	}
+	_, deleted := item[d.softDelete.DeletedAtAttribute]
+	return deleted
+}
+
+// readOptions applies the read options, projecting the deletion attribute so soft-deleted items can be told apart.
+func (d DynamoDBRepository) readOptions(opts []ReadOption) readOptions {
+	options := newReadOptions(opts)
+	if d.softDelete != nil && !options.includeDeleted && len(options.projection) != 0 {
+		options.projection = append(options.projection, d.softDelete.DeletedAtAttribute)
+	}
+	return options
+}
@@ -0,0 +1,45 @@
+package dynamodbcore
+
+import (
+	"context"
+	"time"
+)
+
+// TimeoutPolicy defines the time budget of every DynamoDB call.
+// The budget is the smaller of OperationTimeout and the time left before the context deadline
+// minus SafetyMargin, which is kept free for the handler to respond before Lambda stops it.
+type TimeoutPolicy struct {
+	// OperationTimeout is the maximum duration of a single call, zero for no limit.
+	OperationTimeout time.Duration
+	// SafetyMargin is the time reserved before the context deadline.
+	SafetyMargin time.Duration
+}
+
+// defaultTimeoutPolicy is used when no TimeoutPolicy is configured.
+var defaultTimeoutPolicy = TimeoutPolicy{
+	SafetyMargin: 250 * time.Millisecond,
+}
+
+// operationContext derives the context of a single DynamoDB call from the caller context,
+// failing fast with ErrTimeout when there is no budget left.
+func (d DynamoDBRepository) operationContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return ctx, func() {}, err
+	}
+
+	budget := d.timeoutPolicy.OperationTimeout
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+		remaining := time.Until(deadline) - d.timeoutPolicy.SafetyMargin
+		if remaining <= 0 {
+			return ctx, func() {}, ErrTimeout
+		}
+		if budget <= 0 || remaining < budget {
+			budget = remaining
+		}
+	}
+	if budget <= 0 {
+		return ctx, func() {}, nil
+	}
+	operationCtx, cancel := context.WithTimeout(ctx, budget)
+	return operationCtx, cancel, nil
+}
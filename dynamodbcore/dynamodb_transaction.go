@@ -0,0 +1,508 @@
+package dynamodbcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"strings"
+)
+
+// maxTransactionItems is the maximum number of operations DynamoDB accepts in one transaction.
+const maxTransactionItems = 100
+
+// Transaction operation names reported in cancellation reasons.
+const (
+	TransactionOperationPut            = "Put"
+	TransactionOperationUpdate         = "Update"
+	TransactionOperationDelete         = "Delete"
+	TransactionOperationConditionCheck = "ConditionCheck"
+)
+
+// TransactionBuilder collects put, update, delete and condition-check operations
+// across one or more tables to be executed atomically by TransactWriteCore.
+// An empty table name refers to the table of the repository executing the transaction.
+type TransactionBuilder struct {
+	items              []types.TransactWriteItem
+	operations         []string
+	audited            []bool
+	conditionKinds     []error
+	clientRequestToken string
+	err                error
+}
+
+// NewTransaction creates an empty TransactionBuilder.
+func NewTransaction() *TransactionBuilder {
+	return &TransactionBuilder{}
+}
+
+// WithClientRequestToken makes the transaction idempotent for the given token.
+func (t *TransactionBuilder) WithClientRequestToken(token string) *TransactionBuilder {
+	t.clientRequestToken = token
+	return t
+}
+
+// Put adds a put operation, optionally guarded by conditions.
+func (t *TransactionBuilder) Put(table string, item map[string]types.AttributeValue, conditions ...expression.ConditionBuilder) *TransactionBuilder {
+	put := &types.Put{
+		TableName:                           aws.String(table),
+		Item:                                item,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if condition, hasCondition := joinConditions(conditions); hasCondition {
+		expr, err := expression.NewBuilder().WithCondition(condition).Build()
+		if err != nil {
+			return t.fail(TransactionOperationPut, err)
+		}
+		put.ConditionExpression = expr.Condition()
+		put.ExpressionAttributeNames = expr.Names()
+		put.ExpressionAttributeValues = expr.Values()
+	}
+	return t.add(TransactionOperationPut, types.TransactWriteItem{Put: put})
+}
+
+// Update adds an update operation, optionally guarded by conditions.
+func (t *TransactionBuilder) Update(table string, key Key, update expression.UpdateBuilder, conditions ...expression.ConditionBuilder) *TransactionBuilder {
+	if err := key.Validate(); err != nil {
+		return t.fail(TransactionOperationUpdate, err)
+	}
+	builder := expression.NewBuilder().WithUpdate(update)
+	if condition, hasCondition := joinConditions(conditions); hasCondition {
+		builder = builder.WithCondition(condition)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return t.fail(TransactionOperationUpdate, err)
+	}
+	return t.add(TransactionOperationUpdate, types.TransactWriteItem{Update: &types.Update{
+		TableName:                           aws.String(table),
+		Key:                                 key.AttributeMap(),
+		UpdateExpression:                    expr.Update(),
+		ConditionExpression:                 expr.Condition(),
+		ExpressionAttributeNames:            expr.Names(),
+		ExpressionAttributeValues:           expr.Values(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}})
+}
+
+// Delete adds a delete operation, optionally guarded by conditions.
+func (t *TransactionBuilder) Delete(table string, key Key, conditions ...expression.ConditionBuilder) *TransactionBuilder {
+	if err := key.Validate(); err != nil {
+		return t.fail(TransactionOperationDelete, err)
+	}
+	deleteItem := &types.Delete{
+		TableName:                           aws.String(table),
+		Key:                                 key.AttributeMap(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if condition, hasCondition := joinConditions(conditions); hasCondition {
+		expr, err := expression.NewBuilder().WithCondition(condition).Build()
+		if err != nil {
+			return t.fail(TransactionOperationDelete, err)
+		}
+		deleteItem.ConditionExpression = expr.Condition()
+		deleteItem.ExpressionAttributeNames = expr.Names()
+		deleteItem.ExpressionAttributeValues = expr.Values()
+	}
+	return t.add(TransactionOperationDelete, types.TransactWriteItem{Delete: deleteItem})
+}
+
+// ConditionCheck adds a check that must hold on an item not otherwise written by the transaction.
+func (t *TransactionBuilder) ConditionCheck(table string, key Key, condition expression.ConditionBuilder) *TransactionBuilder {
+	if err := key.Validate(); err != nil {
+		return t.fail(TransactionOperationConditionCheck, err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(condition).Build()
+	if err != nil {
+		return t.fail(TransactionOperationConditionCheck, err)
+	}
+	return t.add(TransactionOperationConditionCheck, types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:                           aws.String(table),
+		Key:                                 key.AttributeMap(),
+		ConditionExpression:                 expr.Condition(),
+		ExpressionAttributeNames:            expr.Names(),
+		ExpressionAttributeValues:           expr.Values(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}})
+}
+
+// Len returns the number of operations in the transaction.
+func (t *TransactionBuilder) Len() int {
+	return len(t.items)
+}
+
+// add appends an operation to the transaction.
+func (t *TransactionBuilder) add(operation string, item types.TransactWriteItem) *TransactionBuilder {
+	t.items = append(t.items, item)
+	t.operations = append(t.operations, operation)
+	t.audited = append(t.audited, false)
+	t.conditionKinds = append(t.conditionKinds, nil)
+	return t
+}
+
+// markAudited marks the last operation as written with the audit of its repository. A transaction that
+// failed to build is never executed, so its operations are left as they are.
+func (t *TransactionBuilder) markAudited() {
+	if t.err == nil && len(t.audited) != 0 {
+		t.audited[len(t.audited)-1] = true
+	}
+}
+
+// setConditionKind makes a failed condition of the last operation fail the transaction with kind.
+func (t *TransactionBuilder) setConditionKind(kind error) {
+	if t.err == nil && len(t.conditionKinds) != 0 {
+		t.conditionKinds[len(t.conditionKinds)-1] = kind
+	}
+}
+
+// conditionKind returns the kind of error of the first failed condition of an operation with a kind set.
+func (t *TransactionBuilder) conditionKind(err error) error {
+	var transactionError *TransactionCanceledError
+	if !errors.As(err, &transactionError) {
+		return nil
+	}
+	for _, reason := range transactionError.Reasons {
+		if reason.Code == "ConditionalCheckFailed" && reason.Index < len(t.conditionKinds) && t.conditionKinds[reason.Index] != nil {
+			return t.conditionKinds[reason.Index]
+		}
+	}
+	return nil
+}
+
+// checkAudited fails when an operation writes the audited table without being marked as audited.
+func (t *TransactionBuilder) checkAudited(table string) error {
+	for index, item := range t.items {
+		if item.ConditionCheck != nil || t.audited[index] {
+			continue
+		}
+		if itemTable := transactionItemTable(item); len(itemTable) == 0 || itemTable == table {
+			return fmt.Errorf("operation %d (%s) writes the audited table %s, use PutItem", index, t.operations[index], table)
+		}
+	}
+	return nil
+}
+
+// fail records the first error found while building the transaction.
+func (t *TransactionBuilder) fail(operation string, err error) *TransactionBuilder {
+	if t.err == nil {
+		t.err = fmt.Errorf("operation %d (%s): %w", len(t.items), operation, err)
+	}
+	return t
+}
+
+// build validates the transaction and returns the input for DynamoDB, defaulting empty table names.
+func (t *TransactionBuilder) build(defaultTable string) (*dynamodb.TransactWriteItemsInput, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if len(t.items) == 0 || len(t.items) > maxTransactionItems {
+		return nil, fmt.Errorf("a transaction must contain between 1 and %d operations, got %d", maxTransactionItems, len(t.items))
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(t.items))
+	for _, item := range t.items {
+		items = append(items, withDefaultTable(item, defaultTable))
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}
+	if len(t.clientRequestToken) != 0 {
+		input.ClientRequestToken = aws.String(t.clientRequestToken)
+	}
+	return input, nil
+}
+
+// withDefaultTable returns a copy of a transaction operation targeting defaultTable when it names no table,
+// leaving the operation held by the builder unchanged so that it can be built again.
+func withDefaultTable(item types.TransactWriteItem, defaultTable string) types.TransactWriteItem {
+	if len(transactionItemTable(item)) != 0 {
+		return item
+	}
+	switch {
+	case item.Put != nil:
+		put := *item.Put
+		put.TableName = aws.String(defaultTable)
+		item.Put = &put
+	case item.Update != nil:
+		update := *item.Update
+		update.TableName = aws.String(defaultTable)
+		item.Update = &update
+	case item.Delete != nil:
+		deleteItem := *item.Delete
+		deleteItem.TableName = aws.String(defaultTable)
+		item.Delete = &deleteItem
+	case item.ConditionCheck != nil:
+		conditionCheck := *item.ConditionCheck
+		conditionCheck.TableName = aws.String(defaultTable)
+		item.ConditionCheck = &conditionCheck
+	}
+	return item
+}
+
+// CancellationReason describes why one operation of a transaction caused its cancellation.
+type CancellationReason struct {
+	Index     int
+	Operation string
+	Table     string
+	Code      string
+	Message   string
+	Item      map[string]types.AttributeValue
+}
+
+// TransactionCanceledError is returned when DynamoDB cancels a transaction.
+// Reasons only lists the operations that caused the cancellation.
+type TransactionCanceledError struct {
+	Reasons []CancellationReason
+	Err     error
+}
+
+// Error returns the string representation of the error.
+func (e *TransactionCanceledError) Error() string {
+	descriptions := make([]string, 0, len(e.Reasons))
+	for _, reason := range e.Reasons {
+		descriptions = append(descriptions, fmt.Sprintf("operation %d (%s %s): %s", reason.Index, reason.Operation, reason.Table, reason.Code))
+	}
+	return "transaction canceled: " + strings.Join(descriptions, ", ")
+}
+
+// Is reports whether any of the cancellation reasons matches the given sentinel error.
+func (e *TransactionCanceledError) Is(target error) bool {
+	for _, reason := range e.Reasons {
+		switch reason.Code {
+		case "ConditionalCheckFailed":
+			if target == ErrConditionFailed {
+				return true
+			}
+		case "TransactionConflict":
+			if target == ErrTransactionConflict {
+				return true
+			}
+		case "ThrottlingError", "ProvisionedThroughputExceeded", "RequestLimitExceeded":
+			if target == ErrThrottled {
+				return true
+			}
+		case "ValidationError", "ItemCollectionSizeLimitExceeded":
+			if target == ErrValidation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Unwrap returns the underlying TransactionCanceledException.
+func (e *TransactionCanceledError) Unwrap() error {
+	return e.Err
+}
+
+// newTransactionCanceledError maps a TransactionCanceledException to the operations that caused it.
+func newTransactionCanceledError(input *dynamodb.TransactWriteItemsInput, operations []string, err error) error {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+
+	transactionError := &TransactionCanceledError{Err: err}
+	for index, reason := range canceled.CancellationReasons {
+		code := aws.ToString(reason.Code)
+		if len(code) == 0 || code == "None" {
+			continue
+		}
+		cancellationReason := CancellationReason{
+			Index:   index,
+			Code:    code,
+			Message: aws.ToString(reason.Message),
+			Item:    reason.Item,
+		}
+		if index < len(operations) {
+			cancellationReason.Operation = operations[index]
+			cancellationReason.Table = transactionItemTable(input.TransactItems[index])
+		}
+		transactionError.Reasons = append(transactionError.Reasons, cancellationReason)
+	}
+	return transactionError
+}
+
+// transactionItemTable returns the table targeted by a transaction operation.
+func transactionItemTable(item types.TransactWriteItem) string {
+	switch {
+	case item.Put != nil:
+		return aws.ToString(item.Put.TableName)
+	case item.Update != nil:
+		return aws.ToString(item.Update.TableName)
+	case item.Delete != nil:
+		return aws.ToString(item.Delete.TableName)
+	case item.ConditionCheck != nil:
+		return aws.ToString(item.ConditionCheck.TableName)
+	}
+	return ""
+}
+
+// TransactGetItem identifies an item to read inside TransactGetCore.
+// An empty table name refers to the table of the repository.
+type TransactGetItem struct {
+	Table string
+	Key   Key
+}
+
+// TransactWriteCore execute every operation of the transaction atomically in DynamoDB.
+// With WithAudit, the table of the repository may only be written by operations added with PutItem.
+func (d DynamoDBRepository) TransactWriteCore(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder) error {
+	logs.LogTrackingInfo("TransactWriteCore", ctx, request)
+	input, errorBuild := transaction.build(d.table)
+	if errorBuild != nil {
+		logs.LogTrackingError("TransactWriteCore", "build", ctx, request, errorBuild)
+		return newOperationError("TransactWriteCore", ErrValidation, errorBuild)
+	}
+	if d.audit != nil {
+		if errorAudit := transaction.checkAudited(d.table); errorAudit != nil {
+			logs.LogTrackingError("TransactWriteCore", "checkAudited", ctx, request, errorAudit)
+			return newOperationError("TransactWriteCore", ErrValidation, errorAudit)
+		}
+	}
+	logs.LogTrackingInfoData("TransactWriteCore input", input, ctx, request)
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("TransactWriteCore", "operationContext", ctx, request, errorTimeout)
+		return wrapError("TransactWriteCore", errorTimeout)
+	}
+	defer cancel()
+	_, err := d.client.TransactWriteItems(operationCtx, input)
+	if err != nil {
+		logs.LogTrackingError("TransactWriteCore", "TransactWriteItems", ctx, request, err)
+		canceledError := newTransactionCanceledError(input, transaction.operations, err)
+		if kind := transaction.conditionKind(canceledError); kind != nil {
+			return newOperationError("TransactWriteCore", kind, canceledError)
+		}
+		return wrapError("TransactWriteCore", canceledError)
+	}
+	return nil
+}
+
+// transactPutter is implemented by the repositories whose puts can be added to a transaction with PutItem.
+type transactPutter interface {
+	transactPut(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder, item map[string]types.AttributeValue, opts []PutOption) *TransactionBuilder
+}
+
+// PutItem adds a put of item in the table of repository, meeting the same conditions and writing the same
+// version, TTL and audit attributes as its PutItemCore with opts, followed by the put of its history record
+// when history is enabled. When the conditions of the put fail, TransactWriteCore fails with ErrAlreadyExists
+// with PutModeCreate and with ErrVersionConflict otherwise, like PutItemCore.
+func (t *TransactionBuilder) PutItem(ctx context.Context, request events.APIGatewayProxyRequest, repository CoreRepository, item map[string]types.AttributeValue, opts ...PutOption) *TransactionBuilder {
+	putter, ok := repository.(transactPutter)
+	if !ok {
+		return t.fail(TransactionOperationPut, fmt.Errorf("repository %T cannot add puts to a transaction", repository))
+	}
+	return putter.transactPut(ctx, request, t, item, opts)
+}
+
+// transactPut adds a put of item in the table of the repository to transaction, see PutItem.
+// With history, the item is read to record its previous values and the put requires it to be unchanged
+// when the transaction runs.
+func (d DynamoDBRepository) transactPut(ctx context.Context, request events.APIGatewayProxyRequest, transaction *TransactionBuilder, item map[string]types.AttributeValue, opts []PutOption) *TransactionBuilder {
+	options, conditions, err := d.preparePut(request, item, opts)
+	if err != nil {
+		return transaction.fail(TransactionOperationPut, err)
+	}
+	if d.audit != nil && options.mode != PutModeCreate {
+		creationConditions, errorStampCreation := d.stampCreation(ctx, item)
+		if errorStampCreation != nil {
+			return transaction.fail(TransactionOperationPut, errorStampCreation)
+		}
+		conditions = append(conditions, creationConditions...)
+	}
+	var before map[string]types.AttributeValue
+	if d.recordsHistory() {
+		if before, err = d.itemBefore(ctx, d.keyOfItem(item)); err != nil {
+			return transaction.fail(TransactionOperationPut, err)
+		}
+		conditions = append(conditions, d.unchangedCondition(before))
+	}
+	transaction.Put(d.table, item, conditions...).markAudited()
+	if options.mode == PutModeCreate {
+		transaction.setConditionKind(ErrAlreadyExists)
+	} else {
+		transaction.setConditionKind(ErrVersionConflict)
+	}
+	if !d.recordsHistory() {
+		return transaction
+	}
+	operation := HistoryOperationPut
+	if options.mode == PutModeCreate {
+		operation = HistoryOperationCreate
+	}
+	change := historyChange{operation: operation, key: d.keyOfItem(item), item: item}
+	record, err := d.historyRecord(ctx, request, change, before, change.after(before))
+	if err != nil {
+		return transaction.fail(TransactionOperationPut, err)
+	}
+	return transaction.Put(d.audit.HistoryTable, record, historyCondition())
+}
+
+// TransactGetCore read several items atomically from DynamoDB.
+// The result keeps the order of items and holds nil for items that do not exist or, in the table of the
+// repository, are soft-deleted. WithProjection applies to every item, and WithConsistentRead changes nothing
+// since transactional reads are always strongly consistent.
+func (d DynamoDBRepository) TransactGetCore(ctx context.Context, request events.APIGatewayProxyRequest, items []TransactGetItem, opts ...ReadOption) ([]map[string]types.AttributeValue, error) {
+	logs.LogTrackingInfo("TransactGetCore", ctx, request)
+	options := d.readOptions(opts)
+	projection, projectionNames, errorProjection := options.projectionExpression()
+	if errorProjection != nil {
+		logs.LogTrackingError("TransactGetCore", "projectionExpression", ctx, request, errorProjection)
+		return nil, newOperationError("TransactGetCore", ErrValidation, errorProjection)
+	}
+	if len(items) == 0 || len(items) > maxTransactionItems {
+		errorItems := fmt.Errorf("a transaction must contain between 1 and %d items, got %d", maxTransactionItems, len(items))
+		logs.LogTrackingError("TransactGetCore", "Validate", ctx, request, errorItems)
+		return nil, newOperationError("TransactGetCore", ErrValidation, errorItems)
+	}
+
+	transactItems := make([]types.TransactGetItem, 0, len(items))
+	for _, item := range items {
+		if errorValidateKey := item.Key.Validate(); errorValidateKey != nil {
+			logs.LogTrackingError("TransactGetCore", "Validate", ctx, request, errorValidateKey)
+			return nil, wrapError("TransactGetCore", errorValidateKey)
+		}
+		table := item.Table
+		if len(table) == 0 {
+			table = d.table
+		}
+		transactItems = append(transactItems, types.TransactGetItem{Get: &types.Get{
+			TableName:                aws.String(table),
+			Key:                      item.Key.AttributeMap(),
+			ProjectionExpression:     projection,
+			ExpressionAttributeNames: projectionNames,
+		}})
+	}
+
+	operationCtx, cancel, errorTimeout := d.operationContext(ctx)
+	if errorTimeout != nil {
+		logs.LogTrackingError("TransactGetCore", "operationContext", ctx, request, errorTimeout)
+		return nil, wrapError("TransactGetCore", errorTimeout)
+	}
+	defer cancel()
+	response, err := d.client.TransactGetItems(operationCtx, &dynamodb.TransactGetItemsInput{TransactItems: transactItems})
+	if err != nil {
+		logs.LogTrackingError("TransactGetCore", "TransactGetItems", ctx, request, err)
+		return nil, wrapError("TransactGetCore", err)
+	}
+
+	result := make([]map[string]types.AttributeValue, len(items))
+	for index, itemResponse := range response.Responses {
+		if index >= len(result) || len(itemResponse.Item) == 0 {
+			continue
+		}
+		ownTable := len(items[index].Table) == 0 || items[index].Table == d.table
+		if ownTable && !options.includeDeleted && d.isSoftDeleted(itemResponse.Item) {
+			continue
+		}
+		result[index] = itemResponse.Item
+	}
+	return result, nil
+}
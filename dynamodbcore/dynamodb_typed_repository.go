@@ -0,0 +1,191 @@
+package dynamodbcore
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"github.com/diegocabrera89/ms-payment-core/tracking"
+	"reflect"
+	"strings"
+)
+
+// Repository is a typed repository for the domain struct T built on top of DynamoDBRepository.
+// Attribute names follow the dynamodbav tags of T, and key fields are those whose attribute names
+// match the key schema of the repository, see WithKeySchema.
+// The API Gateway request used for logging is taken from the context, see tracking.WithRequest.
+type Repository[T any] struct {
+	core         *DynamoDBRepository
+	partitionKey structField
+	sortKey      *structField
+}
+
+// structField locates an attribute inside a struct.
+type structField struct {
+	index []int
+	name  string
+}
+
+// NewRepository creates a Repository for T, discovering its key fields.
+func NewRepository[T any](core *DynamoDBRepository) (*Repository[T], error) {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	if itemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("repository type %s must be a struct", itemType)
+	}
+
+	if len(core.partitionKeyName) == 0 {
+		return nil, fmt.Errorf("repository type %s requires a key schema, see WithKeySchema", itemType)
+	}
+	repository := &Repository[T]{core: core}
+	for _, field := range attributeFields(itemType, nil) {
+		switch {
+		case field.name == core.partitionKeyName:
+			repository.partitionKey = field
+		case len(core.sortKeyName) != 0 && field.name == core.sortKeyName:
+			sortKey := field
+			repository.sortKey = &sortKey
+		}
+	}
+	if len(repository.partitionKey.name) == 0 {
+		return nil, fmt.Errorf("repository type %s has no field for the partition key %s", itemType, core.partitionKeyName)
+	}
+	if len(core.sortKeyName) != 0 && repository.sortKey == nil {
+		return nil, fmt.Errorf("repository type %s has no field for the sort key %s", itemType, core.sortKeyName)
+	}
+	return repository, nil
+}
+
+// KeyOf builds the key of an item from its key fields.
+func (r *Repository[T]) KeyOf(item T) (Key, error) {
+	itemValue := reflect.ValueOf(item)
+	partitionKeyValue, err := attributevalue.Marshal(itemValue.FieldByIndex(r.partitionKey.index).Interface())
+	if err != nil {
+		return Key{}, err
+	}
+	key := NewKey(r.partitionKey.name, partitionKeyValue)
+	if r.sortKey != nil {
+		sortKeyValue, errorSortKey := attributevalue.Marshal(itemValue.FieldByIndex(r.sortKey.index).Interface())
+		if errorSortKey != nil {
+			return Key{}, errorSortKey
+		}
+		key = key.WithSortKey(r.sortKey.name, sortKeyValue)
+	}
+	return key, key.Validate()
+}
+
+// Create stores a new item, failing with ErrAlreadyExists when its key is taken.
+func (r *Repository[T]) Create(ctx context.Context, item T, opts ...PutOption) error {
+	attributes, err := helpers.MarshallItem(item)
+	if err != nil {
+		return newOperationError("Create", ErrValidation, err)
+	}
+	opts = append(append([]PutOption{}, opts...), WithPutMode(PutModeCreate))
+	return r.core.PutItemCore(ctx, tracking.RequestFromContext(ctx), attributes, opts...)
+}
+
+// Put stores an item, replacing any existing item with the same key.
+func (r *Repository[T]) Put(ctx context.Context, item T, opts ...PutOption) error {
+	attributes, err := helpers.MarshallItem(item)
+	if err != nil {
+		return newOperationError("Put", ErrValidation, err)
+	}
+	return r.core.PutItemCore(ctx, tracking.RequestFromContext(ctx), attributes, opts...)
+}
+
+// Get reads the item stored at key, failing with ErrNotFound when there is none.
+func (r *Repository[T]) Get(ctx context.Context, key Key, opts ...ReadOption) (T, error) {
+	var item T
+	response, err := r.core.GetItemCore(ctx, tracking.RequestFromContext(ctx), key, opts...)
+	if err != nil {
+		return item, err
+	}
+	if errorUnmarshal := helpers.UnmarshalMapToType(response.Item, &item); errorUnmarshal != nil {
+		return item, newOperationError("Get", ErrValidation, errorUnmarshal)
+	}
+	return item, nil
+}
+
+// Update writes the attributes of an existing item, see UpdateItemCore for the available options.
+func (r *Repository[T]) Update(ctx context.Context, item T, skipFields []string, opts ...UpdateOption) error {
+	key, err := r.KeyOf(item)
+	if err != nil {
+		return wrapError("Update", err)
+	}
+	return r.core.UpdateItemCore(ctx, tracking.RequestFromContext(ctx), item, key, skipFields, opts...)
+}
+
+// UpdateAndGet writes the attributes of an existing item and returns the item as stored after the update
+// in the same round trip.
+func (r *Repository[T]) UpdateAndGet(ctx context.Context, item T, skipFields []string, opts ...UpdateOption) (T, error) {
+	var updated T
+	opts = append(append([]UpdateOption{}, opts...), WithReturnValues(types.ReturnValueAllNew, &updated))
+	if err := r.Update(ctx, item, skipFields, opts...); err != nil {
+		var zero T
+		return zero, err
+	}
+	return updated, nil
+}
+
+// Delete removes the item stored at key, see DeleteItemCore for the available options.
+func (r *Repository[T]) Delete(ctx context.Context, key Key, opts ...DeleteOption) error {
+	return r.core.DeleteItemCore(ctx, tracking.RequestFromContext(ctx), key, opts...)
+}
+
+// Query reads one page of items whose index field matches value, returning the cursor of the next page.
+func (r *Repository[T]) Query(ctx context.Context, globalSecondaryIndex string, fieldName string, fieldValue string, pageSize int32, cursor string) ([]T, string, error) {
+	page, err := r.core.GetItemsByFieldPageCore(ctx, tracking.RequestFromContext(ctx), fieldName, fieldValue, globalSecondaryIndex, pageSize, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]T, 0, len(page.Items))
+	if errorUnmarshal := helpers.UnmarshalListOfMaps(page.Items, &items); errorUnmarshal != nil {
+		return nil, "", newOperationError("Query", ErrValidation, errorUnmarshal)
+	}
+	return items, page.NextCursor, nil
+}
+
+// Find reads one page of the items matching query, returning the cursor of the next page.
+func (r *Repository[T]) Find(ctx context.Context, query *QueryBuilder, cursor string) ([]T, string, error) {
+	page, err := r.core.QueryCore(ctx, tracking.RequestFromContext(ctx), query, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]T, 0, len(page.Items))
+	if errorUnmarshal := helpers.UnmarshalListOfMaps(page.Items, &items); errorUnmarshal != nil {
+		return nil, "", newOperationError("Find", ErrValidation, errorUnmarshal)
+	}
+	return items, page.NextCursor, nil
+}
+
+// attributeFields lists the exported fields of a struct with the attribute names attributevalue gives them.
+// Embedded structs without a name tag are flattened like attributevalue.MarshalMap does.
+func attributeFields(structType reflect.Type, parentIndex []int) []structField {
+	var fields []structField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		index := append(append([]int{}, parentIndex...), i)
+		name, _, marshalled := helpers.AttributeName(field)
+		if !marshalled {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("dynamodbav"), ",")
+		if field.Anonymous && len(tag) == 0 && fieldType.Kind() == reflect.Struct {
+			// Embedded pointers cannot be reached through FieldByIndex when nil, so only values are flattened.
+			if field.Type.Kind() == reflect.Struct {
+				fields = append(fields, attributeFields(fieldType, index)...)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		fields = append(fields, structField{index: index, name: name})
+	}
+	return fields
+}
@@ -0,0 +1,85 @@
+package dynamodbcore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/helpers"
+	"reflect"
+	"strconv"
+)
+
+// versionCondition builds the condition that the stored version matches the expected one.
+// An expected version of zero means the item must not have a version yet.
+func versionCondition(versionAttribute string, expectedVersion int64) expression.ConditionBuilder {
+	if expectedVersion == 0 {
+		return expression.AttributeNotExists(expression.Name(versionAttribute))
+	}
+	return expression.Equal(expression.Name(versionAttribute), expression.Value(expectedVersion))
+}
+
+// versionFromItem reads the current version stored in an item, zero when it is absent.
+func versionFromItem(item map[string]types.AttributeValue, versionAttribute string) (int64, error) {
+	value, found := item[versionAttribute]
+	if !found {
+		return 0, nil
+	}
+	number, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("version attribute %s must be a number", versionAttribute)
+	}
+	return strconv.ParseInt(number.Value, 10, 64)
+}
+
+// versionFromUpdateValues reads the expected version from the values of an update,
+// reporting whether the values hold the version attribute at all.
+func versionFromUpdateValues(updateValues map[string]interface{}, versionAttribute string) (int64, bool, error) {
+	for fieldName, value := range updateValues {
+		if helpers.SkipUpdatingFields(fieldName, []string{versionAttribute}) {
+			version, err := versionFromValue(value, versionAttribute)
+			return version, true, err
+		}
+	}
+	return 0, false, nil
+}
+
+// versionFromValue converts an integer or pointer to integer into a version number.
+func versionFromValue(value interface{}, versionAttribute string) (int64, error) {
+	reflectValue := reflect.ValueOf(value)
+	for reflectValue.Kind() == reflect.Ptr {
+		if reflectValue.IsNil() {
+			return 0, nil
+		}
+		reflectValue = reflectValue.Elem()
+	}
+	switch reflectValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflectValue.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(reflectValue.Uint()), nil
+	case reflect.Invalid:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("version attribute %s must be an integer", versionAttribute)
+}
+
+// conditionFailedItem returns the stored item returned by a failed conditional check, if any.
+func conditionFailedItem(err error) map[string]types.AttributeValue {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return conditionalCheckFailed.Item
+	}
+	return nil
+}
+
+// joinConditions combines conditions with AND, reporting false when there are none.
+func joinConditions(conditions []expression.ConditionBuilder) (expression.ConditionBuilder, bool) {
+	switch len(conditions) {
+	case 0:
+		return expression.ConditionBuilder{}, false
+	case 1:
+		return conditions[0], true
+	}
+	return expression.And(conditions[0], conditions[1], conditions[2:]...), true
+}
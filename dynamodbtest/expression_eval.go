@@ -0,0 +1,836 @@
+package dynamodbtest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Update expression clauses.
+const (
+	updateSet    = "SET"
+	updateRemove = "REMOVE"
+	updateAdd    = "ADD"
+	updateDelete = "DELETE"
+)
+
+// pathElement is a map key or list index inside a document path.
+type pathElement struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// documentPath addresses an attribute, possibly nested inside maps and lists.
+type documentPath []pathElement
+
+// String returns the path in expression syntax.
+func (d documentPath) String() string {
+	var builder strings.Builder
+	for i, element := range d {
+		if element.isIndex {
+			fmt.Fprintf(&builder, "[%d]", element.index)
+			continue
+		}
+		if i > 0 {
+			builder.WriteString(".")
+		}
+		builder.WriteString(element.name)
+	}
+	return builder.String()
+}
+
+// operand is a value used inside an expression.
+type operand interface {
+	resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error)
+}
+
+// condition is a boolean expression evaluated against an item.
+type condition interface {
+	evaluate(item map[string]types.AttributeValue) (bool, error)
+}
+
+// updateAction is a single action of an update expression.
+type updateAction struct {
+	kind  string
+	path  documentPath
+	value operand
+}
+
+// pathOperand resolves to the attribute at a path.
+type pathOperand struct {
+	path documentPath
+}
+
+func (o pathOperand) resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	value, found := getPath(item, o.path)
+	return value, found, nil
+}
+
+// valueOperand resolves to a literal value.
+type valueOperand struct {
+	value types.AttributeValue
+}
+
+func (o valueOperand) resolve(map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	return o.value, true, nil
+}
+
+// sizeOperand resolves to the size of the attribute at a path.
+type sizeOperand struct {
+	path documentPath
+}
+
+func (o sizeOperand) resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	value, found := getPath(item, o.path)
+	if !found {
+		return nil, false, nil
+	}
+	var size int
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberB:
+		size = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberNS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberL:
+		size = len(v.Value)
+	case *types.AttributeValueMemberM:
+		size = len(v.Value)
+	default:
+		return nil, false, nil
+	}
+	return &types.AttributeValueMemberN{Value: fmt.Sprint(size)}, true, nil
+}
+
+// ifNotExistsOperand resolves to the attribute at a path or to a fallback when it is missing.
+type ifNotExistsOperand struct {
+	path     documentPath
+	fallback operand
+}
+
+func (o ifNotExistsOperand) resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	if value, found := getPath(item, o.path); found {
+		return value, true, nil
+	}
+	return o.fallback.resolve(item)
+}
+
+// listAppendOperand resolves to the concatenation of two lists.
+type listAppendOperand struct {
+	left  operand
+	right operand
+}
+
+func (o listAppendOperand) resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	var result []types.AttributeValue
+	for _, side := range []operand{o.left, o.right} {
+		value, found, err := side.resolve(item)
+		if err != nil {
+			return nil, false, err
+		}
+		list, isList := value.(*types.AttributeValueMemberL)
+		if !found || !isList {
+			return nil, false, fmt.Errorf("list_append operands must be lists")
+		}
+		result = append(result, list.Value...)
+	}
+	return &types.AttributeValueMemberL{Value: result}, true, nil
+}
+
+// arithmeticOperand resolves to the sum or difference of two numbers.
+type arithmeticOperand struct {
+	operator string
+	left     operand
+	right    operand
+}
+
+func (o arithmeticOperand) resolve(item map[string]types.AttributeValue) (types.AttributeValue, bool, error) {
+	left, leftFound, err := o.left.resolve(item)
+	if err != nil {
+		return nil, false, err
+	}
+	right, rightFound, err := o.right.resolve(item)
+	if err != nil {
+		return nil, false, err
+	}
+	leftNumber, leftIsNumber := left.(*types.AttributeValueMemberN)
+	rightNumber, rightIsNumber := right.(*types.AttributeValueMemberN)
+	if !leftFound || !rightFound || !leftIsNumber || !rightIsNumber {
+		return nil, false, fmt.Errorf("an operand in the update expression has an incorrect data type")
+	}
+	if o.operator == "-" {
+		negated, errNegate := negateNumber(rightNumber.Value)
+		if errNegate != nil {
+			return nil, false, errNegate
+		}
+		rightNumber = &types.AttributeValueMemberN{Value: negated}
+	}
+	sum, err := addNumbers(leftNumber.Value, rightNumber.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	return &types.AttributeValueMemberN{Value: sum}, true, nil
+}
+
+// andCondition is true when both sides are true.
+type andCondition struct {
+	left  condition
+	right condition
+}
+
+func (c andCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	left, err := c.left.evaluate(item)
+	if err != nil || !left {
+		return false, err
+	}
+	return c.right.evaluate(item)
+}
+
+// orCondition is true when any side is true.
+type orCondition struct {
+	left  condition
+	right condition
+}
+
+func (c orCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	left, err := c.left.evaluate(item)
+	if err != nil || left {
+		return left, err
+	}
+	return c.right.evaluate(item)
+}
+
+// notCondition negates a condition.
+type notCondition struct {
+	inner condition
+}
+
+func (c notCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	inner, err := c.inner.evaluate(item)
+	return !inner, err
+}
+
+// comparisonCondition compares two operands.
+type comparisonCondition struct {
+	operator string
+	left     operand
+	right    operand
+}
+
+func (c comparisonCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	left, leftFound, err := c.left.resolve(item)
+	if err != nil {
+		return false, err
+	}
+	right, rightFound, err := c.right.resolve(item)
+	if err != nil {
+		return false, err
+	}
+	if !leftFound || !rightFound {
+		return c.operator == "<>", nil
+	}
+	switch c.operator {
+	case "=":
+		return equalValues(left, right), nil
+	case "<>":
+		return !equalValues(left, right), nil
+	}
+	order, comparable := compareValues(left, right)
+	if !comparable {
+		return false, nil
+	}
+	switch c.operator {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	case ">=":
+		return order >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparator %s", c.operator)
+}
+
+// betweenCondition is true when a value is within an inclusive range.
+type betweenCondition struct {
+	value operand
+	low   operand
+	high  operand
+}
+
+func (c betweenCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	lower, err := comparisonCondition{operator: ">=", left: c.value, right: c.low}.evaluate(item)
+	if err != nil || !lower {
+		return false, err
+	}
+	return comparisonCondition{operator: "<=", left: c.value, right: c.high}.evaluate(item)
+}
+
+// inCondition is true when a value equals any of the candidates.
+type inCondition struct {
+	value      operand
+	candidates []operand
+}
+
+func (c inCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	for _, candidate := range c.candidates {
+		matches, err := comparisonCondition{operator: "=", left: c.value, right: candidate}.evaluate(item)
+		if err != nil || matches {
+			return matches, err
+		}
+	}
+	return false, nil
+}
+
+// functionCondition is one of the condition functions of DynamoDB.
+type functionCondition struct {
+	name      string
+	arguments []operand
+}
+
+// functionArity is the number of arguments of every supported condition function.
+var functionArity = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+// newFunctionCondition validates the name and arguments of a condition function.
+func newFunctionCondition(name string, arguments []operand) (condition, error) {
+	arity, supported := functionArity[name]
+	if !supported {
+		return nil, fmt.Errorf("unsupported function %s", name)
+	}
+	if len(arguments) != arity {
+		return nil, fmt.Errorf("function %s expects %d arguments", name, arity)
+	}
+	if _, isPath := arguments[0].(pathOperand); !isPath {
+		return nil, fmt.Errorf("the first argument of %s must be a path", name)
+	}
+	return functionCondition{name: name, arguments: arguments}, nil
+}
+
+func (c functionCondition) evaluate(item map[string]types.AttributeValue) (bool, error) {
+	value, found, err := c.arguments[0].resolve(item)
+	if err != nil {
+		return false, err
+	}
+	switch c.name {
+	case "attribute_exists":
+		return found, nil
+	case "attribute_not_exists":
+		return !found, nil
+	}
+
+	argument, argumentFound, err := c.arguments[1].resolve(item)
+	if err != nil || !found || !argumentFound {
+		return false, err
+	}
+	switch c.name {
+	case "attribute_type":
+		typeName, isString := argument.(*types.AttributeValueMemberS)
+		return isString && typeName.Value == attributeType(value), nil
+	case "begins_with":
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			prefix, isString := argument.(*types.AttributeValueMemberS)
+			return isString && strings.HasPrefix(v.Value, prefix.Value), nil
+		case *types.AttributeValueMemberB:
+			prefix, isBinary := argument.(*types.AttributeValueMemberB)
+			return isBinary && bytes.HasPrefix(v.Value, prefix.Value), nil
+		}
+		return false, nil
+	case "contains":
+		return containsValue(value, argument), nil
+	}
+	return false, fmt.Errorf("unsupported function %s", c.name)
+}
+
+// attributeType returns the DynamoDB type name of a value.
+func attributeType(value types.AttributeValue) string {
+	switch value.(type) {
+	case *types.AttributeValueMemberS:
+		return "S"
+	case *types.AttributeValueMemberN:
+		return "N"
+	case *types.AttributeValueMemberB:
+		return "B"
+	case *types.AttributeValueMemberSS:
+		return "SS"
+	case *types.AttributeValueMemberNS:
+		return "NS"
+	case *types.AttributeValueMemberBS:
+		return "BS"
+	case *types.AttributeValueMemberM:
+		return "M"
+	case *types.AttributeValueMemberL:
+		return "L"
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberBOOL:
+		return "BOOL"
+	}
+	return ""
+}
+
+// containsValue implements the contains function for strings, sets and lists.
+func containsValue(value types.AttributeValue, element types.AttributeValue) bool {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		substring, isString := element.(*types.AttributeValueMemberS)
+		return isString && strings.Contains(v.Value, substring.Value)
+	case *types.AttributeValueMemberB:
+		subsequence, isBinary := element.(*types.AttributeValueMemberB)
+		return isBinary && bytes.Contains(v.Value, subsequence.Value)
+	case *types.AttributeValueMemberL:
+		for _, member := range v.Value {
+			if equalValues(member, element) {
+				return true
+			}
+		}
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		for _, member := range setMembers(v) {
+			if equalValues(member, element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equalValues reports whether two values are equal, ignoring the order of set members.
+func equalValues(left types.AttributeValue, right types.AttributeValue) bool {
+	switch l := left.(type) {
+	case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		order, comparable := compareValues(left, right)
+		return comparable && order == 0
+	case *types.AttributeValueMemberBOOL:
+		r, ok := right.(*types.AttributeValueMemberBOOL)
+		return ok && l.Value == r.Value
+	case *types.AttributeValueMemberNULL:
+		_, ok := right.(*types.AttributeValueMemberNULL)
+		return ok
+	case *types.AttributeValueMemberL:
+		r, ok := right.(*types.AttributeValueMemberL)
+		if !ok || len(l.Value) != len(r.Value) {
+			return false
+		}
+		for i := range l.Value {
+			if !equalValues(l.Value[i], r.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		r, ok := right.(*types.AttributeValueMemberM)
+		if !ok || len(l.Value) != len(r.Value) {
+			return false
+		}
+		for name, value := range l.Value {
+			other, found := r.Value[name]
+			if !found || !equalValues(value, other) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		if attributeType(left) != attributeType(right) {
+			return false
+		}
+		leftMembers, rightMembers := setMembers(left), setMembers(right)
+		if len(leftMembers) != len(rightMembers) {
+			return false
+		}
+		for _, member := range leftMembers {
+			if !containsValue(right, member) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compareValues orders two scalar values of the same type.
+func compareValues(left types.AttributeValue, right types.AttributeValue) (int, bool) {
+	switch l := left.(type) {
+	case *types.AttributeValueMemberS:
+		r, ok := right.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l.Value, r.Value), true
+	case *types.AttributeValueMemberB:
+		r, ok := right.(*types.AttributeValueMemberB)
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(l.Value, r.Value), true
+	case *types.AttributeValueMemberN:
+		r, ok := right.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		leftNumber, leftOk := new(big.Rat).SetString(l.Value)
+		rightNumber, rightOk := new(big.Rat).SetString(r.Value)
+		if !leftOk || !rightOk {
+			return 0, false
+		}
+		return leftNumber.Cmp(rightNumber), true
+	}
+	return 0, false
+}
+
+// setMembers returns the members of a set as individual values.
+func setMembers(set types.AttributeValue) []types.AttributeValue {
+	var members []types.AttributeValue
+	switch s := set.(type) {
+	case *types.AttributeValueMemberSS:
+		for _, member := range s.Value {
+			members = append(members, &types.AttributeValueMemberS{Value: member})
+		}
+	case *types.AttributeValueMemberNS:
+		for _, member := range s.Value {
+			members = append(members, &types.AttributeValueMemberN{Value: member})
+		}
+	case *types.AttributeValueMemberBS:
+		for _, member := range s.Value {
+			members = append(members, &types.AttributeValueMemberB{Value: member})
+		}
+	}
+	return members
+}
+
+// addNumbers adds two decimal numbers without losing precision.
+func addNumbers(left string, right string) (string, error) {
+	leftNumber, leftOk := new(big.Rat).SetString(left)
+	rightNumber, rightOk := new(big.Rat).SetString(right)
+	if !leftOk || !rightOk {
+		return "", fmt.Errorf("invalid number")
+	}
+	return formatNumber(new(big.Rat).Add(leftNumber, rightNumber)), nil
+}
+
+// negateNumber returns the opposite of a decimal number.
+func negateNumber(value string) (string, error) {
+	number, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return "", fmt.Errorf("invalid number %s", value)
+	}
+	return formatNumber(number.Neg(number)), nil
+}
+
+// formatNumber prints a decimal number with the fewest digits that represent it exactly.
+func formatNumber(number *big.Rat) string {
+	if number.IsInt() {
+		return number.Num().String()
+	}
+	scaled := new(big.Rat).Set(number)
+	ten := big.NewRat(10, 1)
+	for digits := 1; digits <= 38; digits++ {
+		scaled.Mul(scaled, ten)
+		if scaled.IsInt() {
+			return number.FloatString(digits)
+		}
+	}
+	return number.FloatString(38)
+}
+
+// getPath returns the value at a path inside an item.
+func getPath(item map[string]types.AttributeValue, path documentPath) (types.AttributeValue, bool) {
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, element := range path {
+		switch container := current.(type) {
+		case *types.AttributeValueMemberM:
+			if element.isIndex {
+				return nil, false
+			}
+			value, found := container.Value[element.name]
+			if !found {
+				return nil, false
+			}
+			current = value
+		case *types.AttributeValueMemberL:
+			if !element.isIndex || element.index >= len(container.Value) {
+				return nil, false
+			}
+			current = container.Value[element.index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath stores a value at a path inside an item. Every parent of the path must exist.
+func setPath(item map[string]types.AttributeValue, path documentPath, value types.AttributeValue) error {
+	parent, found := getPath(item, path[:len(path)-1])
+	if !found {
+		return fmt.Errorf("the document path provided in the update expression is invalid for update: %s", path)
+	}
+	last := path[len(path)-1]
+	switch container := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if !last.isIndex {
+			container.Value[last.name] = value
+			return nil
+		}
+	case *types.AttributeValueMemberL:
+		if last.isIndex {
+			if last.index >= len(container.Value) {
+				container.Value = append(container.Value, value)
+			} else {
+				container.Value[last.index] = value
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("the document path provided in the update expression is invalid for update: %s", path)
+}
+
+// removePath deletes the value at a path inside an item, doing nothing when it does not exist.
+func removePath(item map[string]types.AttributeValue, path documentPath) {
+	parent, found := getPath(item, path[:len(path)-1])
+	if !found {
+		return
+	}
+	last := path[len(path)-1]
+	switch container := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if !last.isIndex {
+			delete(container.Value, last.name)
+		}
+	case *types.AttributeValueMemberL:
+		if last.isIndex && last.index < len(container.Value) {
+			container.Value = append(container.Value[:last.index], container.Value[last.index+1:]...)
+		}
+	}
+}
+
+// applyUpdate returns a copy of item with the update actions applied.
+// Every value is computed from the item as it was before the update, as DynamoDB does.
+func applyUpdate(item map[string]types.AttributeValue, actions []updateAction) (map[string]types.AttributeValue, error) {
+	updated := copyItem(item)
+	var removals []documentPath
+	for _, action := range actions {
+		switch action.kind {
+		case updateSet:
+			value, found, err := action.value.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return nil, fmt.Errorf("the provided expression refers to an attribute that does not exist in the item")
+			}
+			if err := setPath(updated, action.path, copyValue(value)); err != nil {
+				return nil, err
+			}
+		case updateRemove:
+			removals = append(removals, action.path)
+		case updateAdd:
+			value, _, _ := action.value.resolve(item)
+			current, found := getPath(item, action.path)
+			if !found {
+				if err := setPath(updated, action.path, copyValue(value)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			result, err := addValues(current, value)
+			if err != nil {
+				return nil, err
+			}
+			if err := setPath(updated, action.path, result); err != nil {
+				return nil, err
+			}
+		case updateDelete:
+			value, _, _ := action.value.resolve(item)
+			current, found := getPath(item, action.path)
+			if !found {
+				continue
+			}
+			result, err := subtractSet(current, value)
+			if err != nil {
+				return nil, err
+			}
+			if len(setMembers(result)) == 0 {
+				removals = append(removals, action.path)
+				continue
+			}
+			if err := setPath(updated, action.path, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Remove list elements from the highest index so earlier removals do not shift later ones.
+	sort.SliceStable(removals, func(i, j int) bool {
+		last := func(path documentPath) int { return path[len(path)-1].index }
+		return last(removals[i]) > last(removals[j])
+	})
+	for _, path := range removals {
+		removePath(updated, path)
+	}
+	return updated, nil
+}
+
+// addValues implements ADD for numbers and sets.
+func addValues(current types.AttributeValue, value types.AttributeValue) (types.AttributeValue, error) {
+	if attributeType(current) != attributeType(value) {
+		return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+	}
+	switch c := current.(type) {
+	case *types.AttributeValueMemberN:
+		sum, err := addNumbers(c.Value, value.(*types.AttributeValueMemberN).Value)
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberN{Value: sum}, nil
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		members := setMembers(current)
+		for _, member := range setMembers(value) {
+			if !containsValue(current, member) {
+				members = append(members, member)
+			}
+		}
+		return buildSet(attributeType(current), members), nil
+	}
+	return nil, fmt.Errorf("ADD only supports numbers and sets")
+}
+
+// subtractSet implements DELETE for sets.
+func subtractSet(current types.AttributeValue, value types.AttributeValue) (types.AttributeValue, error) {
+	if attributeType(current) != attributeType(value) || len(setMembers(current)) == 0 {
+		return nil, fmt.Errorf("DELETE only supports sets of the same type")
+	}
+	var members []types.AttributeValue
+	for _, member := range setMembers(current) {
+		if !containsValue(value, member) {
+			members = append(members, member)
+		}
+	}
+	return buildSet(attributeType(current), members), nil
+}
+
+// buildSet creates a set of the given type from individual values.
+func buildSet(setType string, members []types.AttributeValue) types.AttributeValue {
+	switch setType {
+	case "SS":
+		set := &types.AttributeValueMemberSS{}
+		for _, member := range members {
+			set.Value = append(set.Value, member.(*types.AttributeValueMemberS).Value)
+		}
+		return set
+	case "NS":
+		set := &types.AttributeValueMemberNS{}
+		for _, member := range members {
+			set.Value = append(set.Value, member.(*types.AttributeValueMemberN).Value)
+		}
+		return set
+	}
+	set := &types.AttributeValueMemberBS{}
+	for _, member := range members {
+		set.Value = append(set.Value, member.(*types.AttributeValueMemberB).Value)
+	}
+	return set
+}
+
+// projectItem returns a copy of item with only the given paths.
+func projectItem(item map[string]types.AttributeValue, paths []documentPath) map[string]types.AttributeValue {
+	if len(paths) == 0 {
+		return copyItem(item)
+	}
+	projected := make(map[string]types.AttributeValue)
+	for _, path := range paths {
+		value, found := getPath(item, path)
+		if !found {
+			continue
+		}
+		// Build the intermediate maps and lists, keeping list elements in their relative order.
+		var container types.AttributeValue = &types.AttributeValueMemberM{Value: projected}
+		for i, element := range path {
+			isLast := i == len(path)-1
+			var next types.AttributeValue
+			if !isLast {
+				if path[i+1].isIndex {
+					next = &types.AttributeValueMemberL{}
+				} else {
+					next = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+				}
+			} else {
+				next = copyValue(value)
+			}
+			switch c := container.(type) {
+			case *types.AttributeValueMemberM:
+				if existing, found := c.Value[element.name]; found && !isLast {
+					next = existing
+				} else {
+					c.Value[element.name] = next
+				}
+			case *types.AttributeValueMemberL:
+				c.Value = append(c.Value, next)
+			}
+			container = next
+		}
+	}
+	return projected
+}
+
+// copyItem returns a deep copy of an item.
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	copied := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		copied[name] = copyValue(value)
+	}
+	return copied
+}
+
+// copyValue returns a deep copy of a value.
+func copyValue(value types.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte{}, v.Value...)}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberBS:
+		copied := make([][]byte, 0, len(v.Value))
+		for _, member := range v.Value {
+			copied = append(copied, append([]byte{}, member...))
+		}
+		return &types.AttributeValueMemberBS{Value: copied}
+	case *types.AttributeValueMemberL:
+		copied := make([]types.AttributeValue, 0, len(v.Value))
+		for _, member := range v.Value {
+			copied = append(copied, copyValue(member))
+		}
+		return &types.AttributeValueMemberL{Value: copied}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	}
+	return value
+}
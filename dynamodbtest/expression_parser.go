@@ -0,0 +1,510 @@
+package dynamodbtest
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind classifies the tokens of an expression.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenNamePlaceholder
+	tokenValuePlaceholder
+	tokenNumber
+	tokenSymbol
+)
+
+// token is a lexical unit of an expression.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits an expression into tokens.
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		current := runes[i]
+		switch {
+		case unicode.IsSpace(current):
+			i++
+		case current == '#' || current == ':' || isIdentifierRune(current):
+			start := i
+			i++
+			for i < len(runes) && isIdentifierRune(runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			kind := tokenIdentifier
+			switch {
+			case current == '#':
+				kind = tokenNamePlaceholder
+			case current == ':':
+				kind = tokenValuePlaceholder
+			case unicode.IsDigit(current):
+				kind = tokenNumber
+			}
+			if (kind == tokenNamePlaceholder || kind == tokenValuePlaceholder) && len(text) == 1 {
+				return nil, fmt.Errorf("invalid placeholder at position %d", start)
+			}
+			tokens = append(tokens, token{kind: kind, text: text})
+		case current == '<' || current == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (current == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, token{kind: tokenSymbol, text: string(runes[i : i+2])})
+				i += 2
+			} else {
+				tokens = append(tokens, token{kind: tokenSymbol, text: string(current)})
+				i++
+			}
+		case strings.ContainsRune("()[],.=+-", current):
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(current)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", current, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// isIdentifierRune reports whether r can be part of an identifier or placeholder.
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parser builds the syntax tree of condition, projection and update expressions.
+// Placeholders are resolved while parsing, so the tree only holds attribute names and values.
+type parser struct {
+	tokens   []token
+	position int
+	names    map[string]string
+	values   map[string]types.AttributeValue
+}
+
+// newParser tokenizes an expression and prepares it for parsing.
+func newParser(expression string, names map[string]string, values map[string]types.AttributeValue) (*parser, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens, names: names, values: values}, nil
+}
+
+// parseCondition parses a condition or key condition expression.
+func parseCondition(expression string, names map[string]string, values map[string]types.AttributeValue) (condition, error) {
+	p, err := newParser(expression, names, values)
+	if err != nil {
+		return nil, err
+	}
+	result, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseProjection parses a projection expression into the list of projected paths.
+func parseProjection(expression string, names map[string]string) ([]documentPath, error) {
+	p, err := newParser(expression, names, nil)
+	if err != nil {
+		return nil, err
+	}
+	var paths []documentPath
+	for {
+		path, errPath := p.parsePath()
+		if errPath != nil {
+			return nil, errPath
+		}
+		paths = append(paths, path)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// parseUpdate parses an update expression into its actions.
+func parseUpdate(expression string, names map[string]string, values map[string]types.AttributeValue) ([]updateAction, error) {
+	p, err := newParser(expression, names, values)
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	seenClauses := make(map[string]bool)
+	for p.peek().kind != tokenEOF {
+		clause := strings.ToUpper(p.peek().text)
+		if p.peek().kind != tokenIdentifier || seenClauses[clause] {
+			return nil, fmt.Errorf("unexpected token %q in update expression", p.peek().text)
+		}
+		seenClauses[clause] = true
+		p.next()
+		for {
+			action, errAction := p.parseUpdateAction(clause)
+			if errAction != nil {
+				return nil, errAction
+			}
+			actions = append(actions, action)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("empty update expression")
+	}
+	return actions, nil
+}
+
+// parseUpdateAction parses a single action of a SET, REMOVE, ADD or DELETE clause.
+func (p *parser) parseUpdateAction(clause string) (updateAction, error) {
+	path, err := p.parsePath()
+	if err != nil {
+		return updateAction{}, err
+	}
+	action := updateAction{kind: clause, path: path}
+	switch clause {
+	case updateSet:
+		if err := p.expectSymbol("="); err != nil {
+			return updateAction{}, err
+		}
+		action.value, err = p.parseSetValue()
+	case updateAdd, updateDelete:
+		action.value, err = p.parseValue()
+	case updateRemove:
+	default:
+		return updateAction{}, fmt.Errorf("unknown update clause %s", clause)
+	}
+	return action, err
+}
+
+// parseSetValue parses the right-hand side of a SET action, including + and - arithmetic.
+func (p *parser) parseSetValue() (operand, error) {
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokenSymbol && (p.peek().text == "+" || p.peek().text == "-") {
+		operator := p.next().text
+		right, errRight := p.parseSetOperand()
+		if errRight != nil {
+			return nil, errRight
+		}
+		return arithmeticOperand{operator: operator, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+// parseSetOperand parses a value, path or update function used by SET.
+func (p *parser) parseSetOperand() (operand, error) {
+	if p.peek().kind == tokenIdentifier && p.peekAt(1).text == "(" {
+		switch strings.ToLower(p.peek().text) {
+		case "if_not_exists":
+			p.next()
+			p.next()
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(","); err != nil {
+				return nil, err
+			}
+			fallback, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return ifNotExistsOperand{path: path, fallback: fallback}, p.expectSymbol(")")
+		case "list_append":
+			p.next()
+			p.next()
+			left, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(","); err != nil {
+				return nil, err
+			}
+			right, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return listAppendOperand{left: left, right: right}, p.expectSymbol(")")
+		}
+	}
+	return p.parseOperand()
+}
+
+// parseOr parses conditions joined by OR.
+func (p *parser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, errRight := p.parseAnd()
+		if errRight != nil {
+			return nil, errRight
+		}
+		left = orCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses conditions joined by AND.
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, errRight := p.parseNot()
+		if errRight != nil {
+			return nil, errRight
+		}
+		left = andCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses an optionally negated condition.
+func (p *parser) parseNot() (condition, error) {
+	if p.acceptKeyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{inner: inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesized condition, a function or a comparison.
+func (p *parser) parsePrimary() (condition, error) {
+	if p.acceptSymbol("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectSymbol(")")
+	}
+
+	if p.peek().kind == tokenIdentifier && p.peekAt(1).text == "(" {
+		name := strings.ToLower(p.peek().text)
+		if name != "size" {
+			p.next()
+			p.next()
+			var arguments []operand
+			for {
+				argument, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				arguments = append(arguments, argument)
+				if !p.acceptSymbol(",") {
+					break
+				}
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return newFunctionCondition(name, arguments)
+		}
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("BETWEEN"):
+		low, errLow := p.parseOperand()
+		if errLow != nil {
+			return nil, errLow
+		}
+		if !p.acceptKeyword("AND") {
+			return nil, fmt.Errorf("expected AND in BETWEEN")
+		}
+		high, errHigh := p.parseOperand()
+		if errHigh != nil {
+			return nil, errHigh
+		}
+		return betweenCondition{value: left, low: low, high: high}, nil
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var candidates []operand
+		for {
+			candidate, errCandidate := p.parseOperand()
+			if errCandidate != nil {
+				return nil, errCandidate
+			}
+			candidates = append(candidates, candidate)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		return inCondition{value: left, candidates: candidates}, p.expectSymbol(")")
+	}
+
+	operator := p.peek()
+	switch operator.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+		p.next()
+	default:
+		return nil, fmt.Errorf("expected comparator, got %q", operator.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparisonCondition{operator: operator.text, left: left, right: right}, nil
+}
+
+// parseOperand parses a path, a value placeholder or a size function.
+func (p *parser) parseOperand() (operand, error) {
+	if p.peek().kind == tokenIdentifier && strings.ToLower(p.peek().text) == "size" && p.peekAt(1).text == "(" {
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand{path: path}, p.expectSymbol(")")
+	}
+	if p.peek().kind == tokenValuePlaceholder {
+		return p.parseValue()
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return pathOperand{path: path}, nil
+}
+
+// parseValue parses a value placeholder.
+func (p *parser) parseValue() (operand, error) {
+	placeholder := p.next()
+	if placeholder.kind != tokenValuePlaceholder {
+		return nil, fmt.Errorf("expected value placeholder, got %q", placeholder.text)
+	}
+	value, found := p.values[placeholder.text]
+	if !found {
+		return nil, fmt.Errorf("value placeholder %s is not defined", placeholder.text)
+	}
+	return valueOperand{value: value}, nil
+}
+
+// parsePath parses a document path such as #a.b[1].
+func (p *parser) parsePath() (documentPath, error) {
+	name, err := p.parsePathName()
+	if err != nil {
+		return nil, err
+	}
+	path := documentPath{{name: name}}
+	for {
+		switch {
+		case p.acceptSymbol("."):
+			name, err = p.parsePathName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{name: name})
+		case p.acceptSymbol("["):
+			indexToken := p.next()
+			index, errIndex := strconv.Atoi(indexToken.text)
+			if indexToken.kind != tokenNumber || errIndex != nil {
+				return nil, fmt.Errorf("invalid list index %q", indexToken.text)
+			}
+			if err := p.expectSymbol("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{index: index, isIndex: true})
+		default:
+			return path, nil
+		}
+	}
+}
+
+// parsePathName parses an attribute name or name placeholder.
+func (p *parser) parsePathName() (string, error) {
+	current := p.next()
+	switch current.kind {
+	case tokenIdentifier:
+		return current.text, nil
+	case tokenNamePlaceholder:
+		name, found := p.names[current.text]
+		if !found {
+			return "", fmt.Errorf("name placeholder %s is not defined", current.text)
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("expected attribute name, got %q", current.text)
+}
+
+// peek returns the current token without consuming it.
+func (p *parser) peek() token {
+	return p.peekAt(0)
+}
+
+// peekAt returns the token offset positions ahead without consuming it.
+func (p *parser) peekAt(offset int) token {
+	if p.position+offset >= len(p.tokens) {
+		return token{kind: tokenEOF}
+	}
+	return p.tokens[p.position+offset]
+}
+
+// next consumes and returns the current token.
+func (p *parser) next() token {
+	current := p.peek()
+	if p.position < len(p.tokens) {
+		p.position++
+	}
+	return current
+}
+
+// acceptSymbol consumes the current token when it is the given symbol.
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.peek().kind == tokenSymbol && p.peek().text == symbol {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// acceptKeyword consumes the current token when it is the given case-insensitive keyword.
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.peek().kind == tokenIdentifier && strings.EqualFold(p.peek().text, keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expectSymbol consumes the given symbol or fails.
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return fmt.Errorf("expected %q, got %q", symbol, p.peek().text)
+	}
+	return nil
+}
+
+// expectEOF fails when there are tokens left.
+func (p *parser) expectEOF() error {
+	if p.peek().kind != tokenEOF {
+		return fmt.Errorf("unexpected token %q", p.peek().text)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package dynamodbtest
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"reflect"
+	"testing"
+)
+
+func s(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func n(value string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: value}
+}
+
+func testItem() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id":      s("payment-1"),
+		"status":  s("PENDING"),
+		"amount":  n("150"),
+		"version": n("2"),
+		"tags":    &types.AttributeValueMemberSS{Value: []string{"card", "online"}},
+		"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"city": s("Quito"),
+		}},
+		"history": &types.AttributeValueMemberL{Value: []types.AttributeValue{s("created"), s("authorized")}},
+	}
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		names      map[string]string
+		values     map[string]types.AttributeValue
+		want       bool
+		wantErr    bool
+	}{
+		{name: "equal", expression: "#s = :s", names: map[string]string{"#s": "status"}, values: map[string]types.AttributeValue{":s": s("PENDING")}, want: true},
+		{name: "not equal", expression: "#s <> :s", names: map[string]string{"#s": "status"}, values: map[string]types.AttributeValue{":s": s("PENDING")}, want: false},
+		{name: "numeric comparison", expression: "amount > :a", values: map[string]types.AttributeValue{":a": n("99.5")}, want: true},
+		{name: "different types never compare", expression: "amount > :a", values: map[string]types.AttributeValue{":a": s("1")}, want: false},
+		{name: "between", expression: "amount BETWEEN :low AND :high", values: map[string]types.AttributeValue{":low": n("100"), ":high": n("200")}, want: true},
+		{name: "in", expression: "#s IN (:a, :b)", names: map[string]string{"#s": "status"}, values: map[string]types.AttributeValue{":a": s("PAID"), ":b": s("PENDING")}, want: true},
+		{name: "and or precedence", expression: "amount < :a OR #s = :s AND version = :v", names: map[string]string{"#s": "status"}, values: map[string]types.AttributeValue{":a": n("1"), ":s": s("PENDING"), ":v": n("2")}, want: true},
+		{name: "not with parentheses", expression: "NOT (amount < :a)", values: map[string]types.AttributeValue{":a": n("1")}, want: true},
+		{name: "attribute exists", expression: "attribute_exists(id)", want: true},
+		{name: "attribute not exists", expression: "attribute_not_exists(deletedAt)", want: true},
+		{name: "attribute type", expression: "attribute_type(tags, :t)", values: map[string]types.AttributeValue{":t": s("SS")}, want: true},
+		{name: "begins with", expression: "begins_with(id, :p)", values: map[string]types.AttributeValue{":p": s("payment-")}, want: true},
+		{name: "contains set", expression: "contains(tags, :t)", values: map[string]types.AttributeValue{":t": s("card")}, want: true},
+		{name: "size", expression: "size(history) = :n", values: map[string]types.AttributeValue{":n": n("2")}, want: true},
+		{name: "nested map path", expression: "address.city = :c", values: map[string]types.AttributeValue{":c": s("Quito")}, want: true},
+		{name: "list index path", expression: "history[1] = :h", values: map[string]types.AttributeValue{":h": s("authorized")}, want: true},
+		{name: "missing attribute", expression: "missing = :m", values: map[string]types.AttributeValue{":m": s("x")}, want: false},
+		{name: "undefined name", expression: "#missing = :s", values: map[string]types.AttributeValue{":s": s("x")}, wantErr: true},
+		{name: "undefined value", expression: "status = :missing", wantErr: true},
+		{name: "unknown function", expression: "unknown(id)", wantErr: true},
+		{name: "trailing tokens", expression: "attribute_exists(id) id", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseCondition(test.expression, test.names, test.values)
+			if err == nil {
+				var got bool
+				got, err = parsed.evaluate(testItem())
+				if err == nil && got != test.want {
+					t.Errorf("evaluate(%q) = %t, want %t", test.expression, got, test.want)
+				}
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("condition %q error = %v, want error %t", test.expression, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyConditions(t *testing.T) {
+	values := map[string]types.AttributeValue{":id": s("payment-1"), ":prefix": s("pay"), ":low": n("100"), ":high": n("150")}
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{
+		{name: "partition key", expression: "id = :id", want: true},
+		{name: "begins with sort key", expression: "id = :id AND begins_with(#s, :prefix)", want: false},
+		{name: "between sort key", expression: "id = :id AND amount BETWEEN :low AND :high", want: true},
+		{name: "greater than sort key", expression: "id = :id AND amount > :high", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseCondition(test.expression, map[string]string{"#s": "status"}, values)
+			if err != nil {
+				t.Fatalf("parseCondition(%q) error = %v", test.expression, err)
+			}
+			got, err := parsed.evaluate(testItem())
+			if err != nil || got != test.want {
+				t.Errorf("evaluate(%q) = %t, %v, want %t", test.expression, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		values     map[string]types.AttributeValue
+		check      string
+		want       types.AttributeValue
+		wantErr    bool
+	}{
+		{name: "set", expression: "SET #s = :s", values: map[string]types.AttributeValue{":s": s("PAID")}, check: "status", want: s("PAID")},
+		{name: "set arithmetic", expression: "SET version = version + :one", values: map[string]types.AttributeValue{":one": n("1")}, check: "version", want: n("3")},
+		{name: "set if not exists", expression: "SET currency = if_not_exists(currency, :c)", values: map[string]types.AttributeValue{":c": s("USD")}, check: "currency", want: s("USD")},
+		{name: "set if not exists keeps value", expression: "SET #s = if_not_exists(#s, :s)", values: map[string]types.AttributeValue{":s": s("PAID")}, check: "status", want: s("PENDING")},
+		{name: "set list append", expression: "SET history = list_append(history, :h)", values: map[string]types.AttributeValue{":h": &types.AttributeValueMemberL{Value: []types.AttributeValue{s("paid")}}}, check: "history",
+			want: &types.AttributeValueMemberL{Value: []types.AttributeValue{s("created"), s("authorized"), s("paid")}}},
+		{name: "set nested path", expression: "SET address.city = :c", values: map[string]types.AttributeValue{":c": s("Guayaquil")}, check: "address",
+			want: &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": s("Guayaquil")}}},
+		{name: "set missing parent", expression: "SET billing.city = :c", values: map[string]types.AttributeValue{":c": s("Quito")}, wantErr: true},
+		{name: "remove", expression: "REMOVE #s", check: "status"},
+		{name: "remove list element", expression: "REMOVE history[0]", check: "history", want: &types.AttributeValueMemberL{Value: []types.AttributeValue{s("authorized")}}},
+		{name: "add number", expression: "ADD amount :a", values: map[string]types.AttributeValue{":a": n("-50")}, check: "amount", want: n("100")},
+		{name: "add missing number", expression: "ADD retries :one", values: map[string]types.AttributeValue{":one": n("1")}, check: "retries", want: n("1")},
+		{name: "add set", expression: "ADD tags :t", values: map[string]types.AttributeValue{":t": &types.AttributeValueMemberSS{Value: []string{"refund"}}}, check: "tags",
+			want: &types.AttributeValueMemberSS{Value: []string{"card", "online", "refund"}}},
+		{name: "add wrong type", expression: "ADD amount :t", values: map[string]types.AttributeValue{":t": s("1")}, wantErr: true},
+		{name: "delete from set", expression: "DELETE tags :t", values: map[string]types.AttributeValue{":t": &types.AttributeValueMemberSS{Value: []string{"online"}}}, check: "tags",
+			want: &types.AttributeValueMemberSS{Value: []string{"card"}}},
+		{name: "delete last members", expression: "DELETE tags :t", values: map[string]types.AttributeValue{":t": &types.AttributeValueMemberSS{Value: []string{"card", "online"}}}, check: "tags"},
+		{name: "several clauses", expression: "SET #s = :s REMOVE history ADD version :one", values: map[string]types.AttributeValue{":s": s("PAID"), ":one": n("1")}, check: "version", want: n("3")},
+		{name: "repeated clause", expression: "SET #s = :s SET amount = :s", values: map[string]types.AttributeValue{":s": s("PAID")}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := testItem()
+			actions, err := parseUpdate(test.expression, map[string]string{"#s": "status"}, test.values)
+			var updated map[string]types.AttributeValue
+			if err == nil {
+				updated, err = applyUpdate(item, actions)
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("update %q error = %v, want error %t", test.expression, err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := updated[test.check]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("update %q set %s to %#v, want %#v", test.expression, test.check, got, test.want)
+			}
+			if !reflect.DeepEqual(item, testItem()) {
+				t.Errorf("update %q modified the original item", test.expression)
+			}
+		})
+	}
+}
+
+func TestProjection(t *testing.T) {
+	paths, err := parseProjection("id, #s, address.city, history[1]", map[string]string{"#s": "status"})
+	if err != nil {
+		t.Fatalf("parseProjection error = %v", err)
+	}
+	want := map[string]types.AttributeValue{
+		"id":      s("payment-1"),
+		"status":  s("PENDING"),
+		"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": s("Quito")}},
+		"history": &types.AttributeValueMemberL{Value: []types.AttributeValue{s("authorized")}},
+	}
+	if got := projectItem(testItem(), paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("projectItem = %#v, want %#v", got, want)
+	}
+}
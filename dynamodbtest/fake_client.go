@@ -0,0 +1,911 @@
+package dynamodbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is an in-memory implementation of dynamodbcore.DynamoDBClientInterface for unit tests.
+// It evaluates key conditions, condition, filter, projection and update expressions and
+// maintains global secondary indexes, returning the same error types as DynamoDB.
+type Client struct {
+	mutex  sync.Mutex
+	tables map[string]*table
+
+	// MaxBatchItemsPerCall limits how many keys or items each batch call processes,
+	// returning the rest as unprocessed. Zero processes everything.
+	MaxBatchItemsPerCall int
+	// ErrorHook, when set, is called before every operation and its error, if any, is returned instead.
+	ErrorHook func(operation string, tableName string) error
+	// Latency delays every operation, which fails with the context error when it ends first.
+	Latency time.Duration
+}
+
+// TableDefinition describes the key schema and indexes of a table.
+type TableDefinition struct {
+	Name                   string
+	PartitionKey           string
+	SortKey                string
+	GlobalSecondaryIndexes []IndexDefinition
+}
+
+// IndexDefinition describes the key schema of a global secondary index.
+type IndexDefinition struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+}
+
+// table holds the items of a table keyed by the fingerprint of their primary key.
+type table struct {
+	definition TableDefinition
+	items      map[string]map[string]types.AttributeValue
+}
+
+var _ dynamodbcore.DynamoDBClientInterface = (*Client)(nil)
+
+// NewClient creates an in-memory client with the given tables.
+func NewClient(definitions ...TableDefinition) *Client {
+	client := &Client{tables: make(map[string]*table)}
+	for _, definition := range definitions {
+		client.CreateTable(definition)
+	}
+	return client
+}
+
+// CreateTable creates or empties a table.
+func (c *Client) CreateTable(definition TableDefinition) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tables[definition.Name] = &table{
+		definition: definition,
+		items:      make(map[string]map[string]types.AttributeValue),
+	}
+}
+
+// Items returns a copy of every item stored in a table, ordered by primary key.
+func (c *Client) Items(tableName string) []map[string]types.AttributeValue {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, found := c.tables[tableName]
+	if !found {
+		return nil
+	}
+	items := storedTable.sortedItems(storedTable.definition.PartitionKey, storedTable.definition.SortKey)
+	for i, item := range items {
+		items[i] = copyItem(item)
+	}
+	return items
+}
+
+// PutItem implements DynamoDB's PutItem operation.
+func (c *Client) PutItem(ctx context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("PutItem", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := storedTable.keyOf(params.Item)
+	if err != nil {
+		return nil, err
+	}
+	existing := storedTable.items[key]
+	if err := checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, existing, params.ReturnValuesOnConditionCheckFailure); err != nil {
+		return nil, err
+	}
+	storedTable.items[key] = copyItem(params.Item)
+
+	output := &dynamodb.PutItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = copyItem(existing)
+	}
+	return output, nil
+}
+
+// GetItem implements DynamoDB's GetItem operation.
+func (c *Client) GetItem(ctx context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("GetItem", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := storedTable.exactKeyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	projection, err := parseOptionalProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, err
+	}
+	output := &dynamodb.GetItemOutput{}
+	if item, found := storedTable.items[key]; found {
+		output.Item = projectItem(item, projection)
+	}
+	return output, nil
+}
+
+// DeleteItem implements DynamoDB's DeleteItem operation.
+func (c *Client) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("DeleteItem", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := storedTable.exactKeyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	existing := storedTable.items[key]
+	if err := checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, existing, params.ReturnValuesOnConditionCheckFailure); err != nil {
+		return nil, err
+	}
+	delete(storedTable.items, key)
+
+	output := &dynamodb.DeleteItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = copyItem(existing)
+	}
+	return output, nil
+}
+
+// UpdateItem implements DynamoDB's UpdateItem operation.
+func (c *Client) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("UpdateItem", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	existing, updated, err := storedTable.prepareUpdate(params.Key, aws.ToString(params.UpdateExpression), params.ConditionExpression,
+		params.ExpressionAttributeNames, params.ExpressionAttributeValues, params.ReturnValuesOnConditionCheckFailure)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := storedTable.keyOf(updated)
+	storedTable.items[key] = updated
+
+	output := &dynamodb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllOld:
+		output.Attributes = copyItem(existing)
+	case types.ReturnValueAllNew:
+		output.Attributes = copyItem(updated)
+	case types.ReturnValueUpdatedOld:
+		output.Attributes = changedAttributes(existing, updated, existing)
+	case types.ReturnValueUpdatedNew:
+		output.Attributes = changedAttributes(existing, updated, updated)
+	}
+	return output, nil
+}
+
+// GetItemByField implements DynamoDB's Query operation.
+func (c *Client) GetItemByField(ctx context.Context, params *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("Query", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	partitionKey, sortKey, err := storedTable.indexKeys(aws.ToString(params.IndexName))
+	if err != nil {
+		return nil, err
+	}
+	keyCondition, err := parseCondition(aws.ToString(params.KeyConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+	filter, err := parseOptionalCondition(params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	projection, err := parseOptionalProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := params.ScanIndexForward == nil || *params.ScanIndexForward
+	orderKeys := storedTable.orderKeys(partitionKey, sortKey)
+	var candidates []map[string]types.AttributeValue
+	for _, item := range storedTable.sortedItems(partitionKey, sortKey) {
+		matches, errMatch := keyCondition.evaluate(item)
+		if errMatch != nil {
+			return nil, validationError(errMatch.Error())
+		}
+		if matches {
+			candidates = append(candidates, item)
+		}
+	}
+	if !forward {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+	candidates = startAfter(candidates, params.ExclusiveStartKey, orderKeys, forward)
+
+	evaluated, lastKey := limitItems(candidates, params.Limit, orderKeys)
+	items, err := filterAndProject(evaluated, filter, projection)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryOutput{
+		Items:            items,
+		Count:            int32(len(items)),
+		ScannedCount:     int32(len(evaluated)),
+		LastEvaluatedKey: lastKey,
+	}, nil
+}
+
+// TransactWriteItems implements DynamoDB's TransactWriteItems operation.
+// Every condition is checked before any write is applied, and a failure cancels the whole transaction.
+func (c *Client) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ErrorHook != nil {
+		if err := c.ErrorHook("TransactWriteItems", ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(params.TransactItems) == 0 || len(params.TransactItems) > 100 {
+		return nil, validationError("transactions must contain between 1 and 100 items")
+	}
+
+	type pendingWrite struct {
+		table *table
+		key   string
+		item  map[string]types.AttributeValue
+	}
+	writes := make([]pendingWrite, 0, len(params.TransactItems))
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	canceled := false
+	touchedKeys := make(map[string]bool)
+	for index, transactItem := range params.TransactItems {
+		var (
+			tableName    *string
+			key          map[string]types.AttributeValue
+			conditionExp *string
+			names        map[string]string
+			values       map[string]types.AttributeValue
+			returnValues types.ReturnValuesOnConditionCheckFailure
+		)
+		switch {
+		case transactItem.Put != nil:
+			tableName, key, conditionExp = transactItem.Put.TableName, transactItem.Put.Item, transactItem.Put.ConditionExpression
+			names, values, returnValues = transactItem.Put.ExpressionAttributeNames, transactItem.Put.ExpressionAttributeValues, transactItem.Put.ReturnValuesOnConditionCheckFailure
+		case transactItem.Update != nil:
+			tableName, key, conditionExp = transactItem.Update.TableName, transactItem.Update.Key, transactItem.Update.ConditionExpression
+			names, values, returnValues = transactItem.Update.ExpressionAttributeNames, transactItem.Update.ExpressionAttributeValues, transactItem.Update.ReturnValuesOnConditionCheckFailure
+		case transactItem.Delete != nil:
+			tableName, key, conditionExp = transactItem.Delete.TableName, transactItem.Delete.Key, transactItem.Delete.ConditionExpression
+			names, values, returnValues = transactItem.Delete.ExpressionAttributeNames, transactItem.Delete.ExpressionAttributeValues, transactItem.Delete.ReturnValuesOnConditionCheckFailure
+		case transactItem.ConditionCheck != nil:
+			tableName, key, conditionExp = transactItem.ConditionCheck.TableName, transactItem.ConditionCheck.Key, transactItem.ConditionCheck.ConditionExpression
+			names, values, returnValues = transactItem.ConditionCheck.ExpressionAttributeNames, transactItem.ConditionCheck.ExpressionAttributeValues, transactItem.ConditionCheck.ReturnValuesOnConditionCheckFailure
+		default:
+			return nil, validationError("a transaction item must contain one operation")
+		}
+
+		storedTable, err := c.table("", tableName)
+		if err != nil {
+			return nil, err
+		}
+		itemKey, err := storedTable.keyOf(key)
+		if err != nil {
+			return nil, err
+		}
+		if touchedKeys[storedTable.definition.Name+"/"+itemKey] {
+			return nil, validationError("transaction request cannot include multiple operations on one item")
+		}
+		touchedKeys[storedTable.definition.Name+"/"+itemKey] = true
+
+		reasons[index] = types.CancellationReason{Code: aws.String("None")}
+		existing := storedTable.items[itemKey]
+		if errCondition := checkCondition(conditionExp, names, values, existing, returnValues); errCondition != nil {
+			var conditionFailed *types.ConditionalCheckFailedException
+			if !errors.As(errCondition, &conditionFailed) {
+				return nil, errCondition
+			}
+			reasons[index] = types.CancellationReason{
+				Code:    aws.String("ConditionalCheckFailed"),
+				Message: aws.String("The conditional request failed"),
+				Item:    conditionFailed.Item,
+			}
+			canceled = true
+			continue
+		}
+
+		switch {
+		case transactItem.Put != nil:
+			writes = append(writes, pendingWrite{table: storedTable, key: itemKey, item: copyItem(transactItem.Put.Item)})
+		case transactItem.Update != nil:
+			actions, errParse := parseUpdate(aws.ToString(transactItem.Update.UpdateExpression), names, values)
+			if errParse != nil {
+				return nil, validationError(errParse.Error())
+			}
+			base := existing
+			if base == nil {
+				base = copyItem(transactItem.Update.Key)
+			}
+			updated, errApply := applyUpdate(base, actions)
+			if errApply != nil {
+				return nil, validationError(errApply.Error())
+			}
+			writes = append(writes, pendingWrite{table: storedTable, key: itemKey, item: updated})
+		case transactItem.Delete != nil:
+			writes = append(writes, pendingWrite{table: storedTable, key: itemKey})
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+
+	for _, write := range writes {
+		if write.item == nil {
+			delete(write.table.items, write.key)
+			continue
+		}
+		write.table.items[write.key] = write.item
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// TransactGetItems implements DynamoDB's TransactGetItems operation.
+func (c *Client) TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ErrorHook != nil {
+		if err := c.ErrorHook("TransactGetItems", ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(params.TransactItems) == 0 || len(params.TransactItems) > 100 {
+		return nil, validationError("transactions must contain between 1 and 100 items")
+	}
+
+	output := &dynamodb.TransactGetItemsOutput{}
+	for _, transactItem := range params.TransactItems {
+		if transactItem.Get == nil {
+			return nil, validationError("a transaction item must contain a get operation")
+		}
+		storedTable, err := c.table("", transactItem.Get.TableName)
+		if err != nil {
+			return nil, err
+		}
+		key, err := storedTable.exactKeyOf(transactItem.Get.Key)
+		if err != nil {
+			return nil, err
+		}
+		projection, err := parseOptionalProjection(transactItem.Get.ProjectionExpression, transactItem.Get.ExpressionAttributeNames)
+		if err != nil {
+			return nil, err
+		}
+		response := types.ItemResponse{}
+		if item, found := storedTable.items[key]; found {
+			response.Item = projectItem(item, projection)
+		}
+		output.Responses = append(output.Responses, response)
+	}
+	return output, nil
+}
+
+// BatchGetItem implements DynamoDB's BatchGetItem operation.
+func (c *Client) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ErrorHook != nil {
+		if err := c.ErrorHook("BatchGetItem", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	total := 0
+	for _, keysAndAttributes := range params.RequestItems {
+		total += len(keysAndAttributes.Keys)
+	}
+	if total == 0 || total > 100 {
+		return nil, validationError("too many items requested for the BatchGetItem call")
+	}
+
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       make(map[string][]map[string]types.AttributeValue),
+		UnprocessedKeys: make(map[string]types.KeysAndAttributes),
+	}
+	processed := 0
+	for tableName, keysAndAttributes := range params.RequestItems {
+		storedTable, err := c.table("", aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		projection, err := parseOptionalProjection(keysAndAttributes.ProjectionExpression, keysAndAttributes.ExpressionAttributeNames)
+		if err != nil {
+			return nil, err
+		}
+		seenKeys := make(map[string]bool)
+		for _, requestKey := range keysAndAttributes.Keys {
+			key, errKey := storedTable.exactKeyOf(requestKey)
+			if errKey != nil {
+				return nil, errKey
+			}
+			if seenKeys[key] {
+				return nil, validationError("provided list of item keys contains duplicates")
+			}
+			seenKeys[key] = true
+
+			if c.MaxBatchItemsPerCall > 0 && processed >= c.MaxBatchItemsPerCall {
+				unprocessed := output.UnprocessedKeys[tableName]
+				unprocessed.Keys = append(unprocessed.Keys, requestKey)
+				unprocessed.ProjectionExpression = keysAndAttributes.ProjectionExpression
+				unprocessed.ExpressionAttributeNames = keysAndAttributes.ExpressionAttributeNames
+				unprocessed.ConsistentRead = keysAndAttributes.ConsistentRead
+				output.UnprocessedKeys[tableName] = unprocessed
+				continue
+			}
+			processed++
+			if item, found := storedTable.items[key]; found {
+				output.Responses[tableName] = append(output.Responses[tableName], projectItem(item, projection))
+			}
+		}
+	}
+	return output, nil
+}
+
+// BatchWriteItem implements DynamoDB's BatchWriteItem operation.
+func (c *Client) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ErrorHook != nil {
+		if err := c.ErrorHook("BatchWriteItem", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	total := 0
+	for _, writeRequests := range params.RequestItems {
+		total += len(writeRequests)
+	}
+	if total == 0 || total > 25 {
+		return nil, validationError("too many items requested for the BatchWriteItem call")
+	}
+
+	output := &dynamodb.BatchWriteItemOutput{
+		UnprocessedItems: make(map[string][]types.WriteRequest),
+	}
+	processed := 0
+	seenKeys := make(map[string]bool)
+	for tableName, writeRequests := range params.RequestItems {
+		storedTable, err := c.table("", aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		for _, writeRequest := range writeRequests {
+			var key string
+			var errKey error
+			switch {
+			case writeRequest.PutRequest != nil:
+				key, errKey = storedTable.keyOf(writeRequest.PutRequest.Item)
+			case writeRequest.DeleteRequest != nil:
+				key, errKey = storedTable.exactKeyOf(writeRequest.DeleteRequest.Key)
+			default:
+				errKey = validationError("a write request must contain a put or a delete")
+			}
+			if errKey != nil {
+				return nil, errKey
+			}
+			if seenKeys[tableName+"/"+key] {
+				return nil, validationError("provided list of item keys contains duplicates")
+			}
+			seenKeys[tableName+"/"+key] = true
+
+			if c.MaxBatchItemsPerCall > 0 && processed >= c.MaxBatchItemsPerCall {
+				output.UnprocessedItems[tableName] = append(output.UnprocessedItems[tableName], writeRequest)
+				continue
+			}
+			processed++
+			if writeRequest.PutRequest != nil {
+				storedTable.items[key] = copyItem(writeRequest.PutRequest.Item)
+			} else {
+				delete(storedTable.items, key)
+			}
+		}
+	}
+	return output, nil
+}
+
+// Scan implements DynamoDB's Scan operation.
+// Items are assigned to parallel scan segments by a hash of their primary key.
+func (c *Client) Scan(ctx context.Context, params *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	storedTable, err := c.table("Scan", params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	partitionKey, sortKey, err := storedTable.indexKeys(aws.ToString(params.IndexName))
+	if err != nil {
+		return nil, err
+	}
+	totalSegments := aws.ToInt32(params.TotalSegments)
+	segment := aws.ToInt32(params.Segment)
+	if (params.TotalSegments == nil) != (params.Segment == nil) || (params.TotalSegments != nil && (totalSegments < 1 || segment < 0 || segment >= totalSegments)) {
+		return nil, validationError("Segment must be lower than TotalSegments and both must be set together")
+	}
+	filter, err := parseOptionalCondition(params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	projection, err := parseOptionalProjection(params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	orderKeys := storedTable.orderKeys(partitionKey, sortKey)
+	var candidates []map[string]types.AttributeValue
+	for _, item := range storedTable.sortedItems(partitionKey, sortKey) {
+		if params.TotalSegments != nil {
+			key, _ := storedTable.keyOf(item)
+			hash := fnv.New32a()
+			hash.Write([]byte(key))
+			if int32(hash.Sum32()%uint32(totalSegments)) != segment {
+				continue
+			}
+		}
+		candidates = append(candidates, item)
+	}
+	candidates = startAfter(candidates, params.ExclusiveStartKey, orderKeys, true)
+
+	evaluated, lastKey := limitItems(candidates, params.Limit, orderKeys)
+	items, err := filterAndProject(evaluated, filter, projection)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.ScanOutput{
+		Items:            items,
+		Count:            int32(len(items)),
+		ScannedCount:     int32(len(evaluated)),
+		LastEvaluatedKey: lastKey,
+	}, nil
+}
+
+// wait simulates the latency of an operation, returning the context error when the context ends.
+func (c *Client) wait(ctx context.Context) error {
+	if c.Latency > 0 {
+		timer := time.NewTimer(c.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+	return ctx.Err()
+}
+
+// table returns a table by name, calling the error hook for single-table operations.
+func (c *Client) table(operation string, tableName *string) (*table, error) {
+	name := aws.ToString(tableName)
+	if len(operation) != 0 && c.ErrorHook != nil {
+		if err := c.ErrorHook(operation, name); err != nil {
+			return nil, err
+		}
+	}
+	storedTable, found := c.tables[name]
+	if !found {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: Table: " + name + " not found")}
+	}
+	return storedTable, nil
+}
+
+// prepareUpdate checks the condition of an update and computes the updated item without storing it.
+func (t *table) prepareUpdate(requestKey map[string]types.AttributeValue, updateExpression string, conditionExpression *string,
+	names map[string]string, values map[string]types.AttributeValue, returnValues types.ReturnValuesOnConditionCheckFailure) (map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	key, err := t.exactKeyOf(requestKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	actions, err := parseUpdate(updateExpression, names, values)
+	if err != nil {
+		return nil, nil, validationError(err.Error())
+	}
+	for _, action := range actions {
+		if action.path[0].name == t.definition.PartitionKey || action.path[0].name == t.definition.SortKey {
+			return nil, nil, validationError("cannot update attribute " + action.path[0].name + ". This attribute is part of the key")
+		}
+	}
+
+	existing := t.items[key]
+	if err := checkCondition(conditionExpression, names, values, existing, returnValues); err != nil {
+		return nil, nil, err
+	}
+	base := existing
+	if base == nil {
+		base = copyItem(requestKey)
+	}
+	updated, err := applyUpdate(base, actions)
+	if err != nil {
+		return nil, nil, validationError(err.Error())
+	}
+	return existing, updated, nil
+}
+
+// keyOf returns the fingerprint of the primary key of an item, validating its key attributes.
+func (t *table) keyOf(item map[string]types.AttributeValue) (string, error) {
+	names := []string{t.definition.PartitionKey}
+	if len(t.definition.SortKey) != 0 {
+		names = append(names, t.definition.SortKey)
+	}
+	var fingerprint strings.Builder
+	for _, name := range names {
+		value, found := item[name]
+		if !found {
+			return "", validationError("one or more parameter values were invalid: missing the key " + name + " in the item")
+		}
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			fingerprint.WriteString("S:" + v.Value)
+		case *types.AttributeValueMemberN:
+			number, _ := addNumbers(v.Value, "0")
+			fingerprint.WriteString("N:" + number)
+		case *types.AttributeValueMemberB:
+			fingerprint.WriteString(fmt.Sprintf("B:%x", v.Value))
+		default:
+			return "", validationError("one or more parameter values were invalid: type mismatch for key " + name)
+		}
+		fingerprint.WriteString("|")
+	}
+	return fingerprint.String(), nil
+}
+
+// exactKeyOf validates that a key has exactly the key attributes of the table and returns its fingerprint.
+func (t *table) exactKeyOf(key map[string]types.AttributeValue) (string, error) {
+	expected := 1
+	if len(t.definition.SortKey) != 0 {
+		expected = 2
+	}
+	if len(key) != expected {
+		return "", validationError("the provided key element does not match the schema")
+	}
+	return t.keyOf(key)
+}
+
+// indexKeys returns the key attributes of the table or of one of its global secondary indexes.
+func (t *table) indexKeys(indexName string) (string, string, error) {
+	if len(indexName) == 0 {
+		return t.definition.PartitionKey, t.definition.SortKey, nil
+	}
+	for _, index := range t.definition.GlobalSecondaryIndexes {
+		if index.Name == indexName {
+			return index.PartitionKey, index.SortKey, nil
+		}
+	}
+	return "", "", validationError("the table does not have the specified index: " + indexName)
+}
+
+// orderKeys returns the attributes that identify and order an item inside an index.
+func (t *table) orderKeys(partitionKey string, sortKey string) []string {
+	var names []string
+	for _, name := range []string{partitionKey, sortKey, t.definition.PartitionKey, t.definition.SortKey} {
+		if len(name) != 0 && !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sortedItems returns the items present in an index ordered by its keys.
+// Items without the index partition key or sort key are not part of the index.
+func (t *table) sortedItems(partitionKey string, sortKey string) []map[string]types.AttributeValue {
+	items := make([]map[string]types.AttributeValue, 0, len(t.items))
+	for _, item := range t.items {
+		if _, found := item[partitionKey]; !found {
+			continue
+		}
+		if _, found := item[sortKey]; len(sortKey) != 0 && !found {
+			continue
+		}
+		items = append(items, item)
+	}
+	orderKeys := t.orderKeys(partitionKey, sortKey)
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareByKeys(items[i], items[j], orderKeys) < 0
+	})
+	return items
+}
+
+// compareByKeys orders two items by the given key attributes.
+func compareByKeys(left map[string]types.AttributeValue, right map[string]types.AttributeValue, names []string) int {
+	for _, name := range names {
+		leftValue, leftFound := left[name]
+		rightValue, rightFound := right[name]
+		if !leftFound || !rightFound {
+			continue
+		}
+		if order, comparable := compareValues(leftValue, rightValue); comparable && order != 0 {
+			return order
+		}
+	}
+	return 0
+}
+
+// startAfter drops the items up to and including the exclusive start key.
+func startAfter(items []map[string]types.AttributeValue, exclusiveStartKey map[string]types.AttributeValue, orderKeys []string, forward bool) []map[string]types.AttributeValue {
+	if len(exclusiveStartKey) == 0 {
+		return items
+	}
+	for i, item := range items {
+		order := compareByKeys(item, exclusiveStartKey, orderKeys)
+		if (forward && order > 0) || (!forward && order < 0) {
+			return items[i:]
+		}
+	}
+	return nil
+}
+
+// limitItems keeps at most limit items, returning the key to continue from when items were left out.
+func limitItems(items []map[string]types.AttributeValue, limit *int32, orderKeys []string) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	if limit == nil || int(*limit) >= len(items) {
+		return items, nil
+	}
+	evaluated := items[:*limit]
+	lastKey := make(map[string]types.AttributeValue, len(orderKeys))
+	if len(evaluated) == 0 {
+		return evaluated, nil
+	}
+	last := evaluated[len(evaluated)-1]
+	for _, name := range orderKeys {
+		lastKey[name] = copyValue(last[name])
+	}
+	return evaluated, lastKey
+}
+
+// filterAndProject applies a filter and a projection to the evaluated items.
+func filterAndProject(items []map[string]types.AttributeValue, filter condition, projection []documentPath) ([]map[string]types.AttributeValue, error) {
+	result := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		if filter != nil {
+			passes, err := filter.evaluate(item)
+			if err != nil {
+				return nil, validationError(err.Error())
+			}
+			if !passes {
+				continue
+			}
+		}
+		result = append(result, projectItem(item, projection))
+	}
+	return result, nil
+}
+
+// checkCondition evaluates an optional condition expression against the stored item.
+func checkCondition(conditionExpression *string, names map[string]string, values map[string]types.AttributeValue,
+	existing map[string]types.AttributeValue, returnValues types.ReturnValuesOnConditionCheckFailure) error {
+	parsedCondition, err := parseOptionalCondition(conditionExpression, names, values)
+	if err != nil || parsedCondition == nil {
+		return err
+	}
+	if existing == nil {
+		existing = map[string]types.AttributeValue{}
+	}
+	passes, err := parsedCondition.evaluate(existing)
+	if err != nil {
+		return validationError(err.Error())
+	}
+	if passes {
+		return nil
+	}
+	conditionFailed := &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	if returnValues == types.ReturnValuesOnConditionCheckFailureAllOld && len(existing) != 0 {
+		conditionFailed.Item = copyItem(existing)
+	}
+	return conditionFailed
+}
+
+// parseOptionalCondition parses a condition expression when present.
+func parseOptionalCondition(expression *string, names map[string]string, values map[string]types.AttributeValue) (condition, error) {
+	if len(aws.ToString(expression)) == 0 {
+		return nil, nil
+	}
+	parsedCondition, err := parseCondition(*expression, names, values)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+	return parsedCondition, nil
+}
+
+// parseOptionalProjection parses a projection expression when present.
+func parseOptionalProjection(expression *string, names map[string]string) ([]documentPath, error) {
+	if len(aws.ToString(expression)) == 0 {
+		return nil, nil
+	}
+	paths, err := parseProjection(*expression, names)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+	return paths, nil
+}
+
+// changedAttributes returns the top-level attributes of source that differ between before and after.
+func changedAttributes(before map[string]types.AttributeValue, after map[string]types.AttributeValue, source map[string]types.AttributeValue) map[string]types.AttributeValue {
+	changed := make(map[string]types.AttributeValue)
+	for name := range mergeNames(before, after) {
+		beforeValue, beforeFound := before[name]
+		afterValue, afterFound := after[name]
+		if beforeFound && afterFound && equalValues(beforeValue, afterValue) {
+			continue
+		}
+		if value, found := source[name]; found {
+			changed[name] = copyValue(value)
+		}
+	}
+	return changed
+}
+
+// mergeNames returns the attribute names present in any of the items.
+func mergeNames(items ...map[string]types.AttributeValue) map[string]bool {
+	names := make(map[string]bool)
+	for _, item := range items {
+		for name := range item {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// containsString reports whether a slice contains a string.
+func containsString(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validationError builds the error DynamoDB returns for invalid requests.
+func validationError(message string) error {
+	return &smithy.GenericAPIError{Code: "ValidationException", Message: message, Fault: smithy.FaultClient}
+}
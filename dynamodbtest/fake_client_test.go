@@ -0,0 +1,302 @@
+package dynamodbtest
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newPaymentsClient(t *testing.T) *Client {
+	t.Helper()
+	client := NewClient(TableDefinition{
+		Name:         "payments",
+		PartitionKey: "merchantID",
+		SortKey:      "paymentID",
+		GlobalSecondaryIndexes: []IndexDefinition{
+			{Name: "status-index", PartitionKey: "status", SortKey: "amount"},
+		},
+	})
+	payments := []struct {
+		merchant, payment, status, amount string
+	}{
+		{"m1", "p1", "PAID", "30"},
+		{"m1", "p2", "PENDING", "10"},
+		{"m1", "p3", "PAID", "20"},
+		{"m2", "p4", "PAID", "40"},
+		{"m2", "p5", "", "50"},
+	}
+	for _, payment := range payments {
+		item := map[string]types.AttributeValue{
+			"merchantID": s(payment.merchant),
+			"paymentID":  s(payment.payment),
+			"amount":     n(payment.amount),
+		}
+		if len(payment.status) != 0 {
+			item["status"] = s(payment.status)
+		}
+		if _, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String("payments"), Item: item}); err != nil {
+			t.Fatalf("PutItem error = %v", err)
+		}
+	}
+	return client
+}
+
+func paymentIDs(items []map[string]types.AttributeValue) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item["paymentID"].(*types.AttributeValueMemberS).Value)
+	}
+	return ids
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     dynamodb.QueryInput
+		want      []string
+		wantErr   bool
+		wantPaged bool
+	}{
+		{
+			name: "table in sort key order",
+			input: dynamodb.QueryInput{
+				KeyConditionExpression:    aws.String("merchantID = :m"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{":m": s("m1")},
+			},
+			want: []string{"p1", "p2", "p3"},
+		},
+		{
+			name: "descending with sort key condition",
+			input: dynamodb.QueryInput{
+				KeyConditionExpression:    aws.String("merchantID = :m AND paymentID > :p"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{":m": s("m1"), ":p": s("p1")},
+				ScanIndexForward:          aws.Bool(false),
+			},
+			want: []string{"p3", "p2"},
+		},
+		{
+			name: "global secondary index ordered by its sort key",
+			input: dynamodb.QueryInput{
+				IndexName:                 aws.String("status-index"),
+				KeyConditionExpression:    aws.String("#s = :s"),
+				ExpressionAttributeNames:  map[string]string{"#s": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":s": s("PAID")},
+			},
+			want: []string{"p3", "p1", "p4"},
+		},
+		{
+			name: "filter applied after limit",
+			input: dynamodb.QueryInput{
+				KeyConditionExpression:    aws.String("merchantID = :m"),
+				FilterExpression:          aws.String("#s = :s"),
+				ExpressionAttributeNames:  map[string]string{"#s": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":m": s("m1"), ":s": s("PENDING")},
+				Limit:                     aws.Int32(1),
+			},
+			want:      []string{},
+			wantPaged: true,
+		},
+		{
+			name: "unknown index",
+			input: dynamodb.QueryInput{
+				IndexName:                 aws.String("missing-index"),
+				KeyConditionExpression:    aws.String("merchantID = :m"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{":m": s("m1")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newPaymentsClient(t)
+			input := test.input
+			input.TableName = aws.String("payments")
+			output, err := client.GetItemByField(context.Background(), &input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Query error = %v, want error %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := paymentIDs(output.Items); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Query items = %v, want %v", got, test.want)
+			}
+			if paged := output.LastEvaluatedKey != nil; paged != test.wantPaged {
+				t.Errorf("Query LastEvaluatedKey = %v, want one %t", output.LastEvaluatedKey, test.wantPaged)
+			}
+		})
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		indexName *string
+		condition string
+		values    map[string]types.AttributeValue
+		want      []string
+	}{
+		{name: "table", condition: "merchantID = :m", values: map[string]types.AttributeValue{":m": s("m1")}, want: []string{"p1", "p2", "p3"}},
+		{name: "index", indexName: aws.String("status-index"), condition: "#s = :s", values: map[string]types.AttributeValue{":s": s("PAID")}, want: []string{"p3", "p1", "p4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newPaymentsClient(t)
+			var got []string
+			var startKey map[string]types.AttributeValue
+			for pages := 1; ; pages++ {
+				output, err := client.GetItemByField(context.Background(), &dynamodb.QueryInput{
+					TableName:                 aws.String("payments"),
+					IndexName:                 test.indexName,
+					KeyConditionExpression:    aws.String(test.condition),
+					ExpressionAttributeNames:  map[string]string{"#s": "status"},
+					ExpressionAttributeValues: test.values,
+					ExclusiveStartKey:         startKey,
+					Limit:                     aws.Int32(2),
+				})
+				if err != nil {
+					t.Fatalf("Query page %d error = %v", pages, err)
+				}
+				got = append(got, paymentIDs(output.Items)...)
+				if output.LastEvaluatedKey == nil {
+					break
+				}
+				if pages > len(test.want) {
+					t.Fatalf("Query did not stop paging")
+				}
+				startKey = output.LastEvaluatedKey
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Query pages = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	key := map[string]types.AttributeValue{"merchantID": s("m1"), "paymentID": s("p1")}
+	tests := []struct {
+		name    string
+		write   func(client *Client) error
+		wantErr bool
+	}{
+		{
+			name: "put if not exists on existing item",
+			write: func(client *Client) error {
+				_, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{
+					TableName:           aws.String("payments"),
+					Item:                key,
+					ConditionExpression: aws.String("attribute_not_exists(merchantID)"),
+				})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "update with matching condition",
+			write: func(client *Client) error {
+				_, err := client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+					TableName:                 aws.String("payments"),
+					Key:                       key,
+					UpdateExpression:          aws.String("SET #s = :paid"),
+					ConditionExpression:       aws.String("#s = :paid"),
+					ExpressionAttributeNames:  map[string]string{"#s": "status"},
+					ExpressionAttributeValues: map[string]types.AttributeValue{":paid": s("PAID")},
+				})
+				return err
+			},
+		},
+		{
+			name: "delete with failing condition",
+			write: func(client *Client) error {
+				_, err := client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+					TableName:                 aws.String("payments"),
+					Key:                       key,
+					ConditionExpression:       aws.String("amount > :a"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{":a": n("100")},
+				})
+				return err
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.write(newPaymentsClient(t))
+			var conditionFailed *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailed) != test.wantErr {
+				t.Errorf("write error = %v, want condition failure %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransactWriteItemsCancellation(t *testing.T) {
+	client := newPaymentsClient(t)
+	_, err := client.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName: aws.String("payments"),
+				Item:      map[string]types.AttributeValue{"merchantID": s("m3"), "paymentID": s("p6")},
+			}},
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:                           aws.String("payments"),
+				Key:                                 map[string]types.AttributeValue{"merchantID": s("m1"), "paymentID": s("p2")},
+				ConditionExpression:                 aws.String("#s = :paid"),
+				ExpressionAttributeNames:            map[string]string{"#s": "status"},
+				ExpressionAttributeValues:           map[string]types.AttributeValue{":paid": s("PAID")},
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			}},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		t.Fatalf("TransactWriteItems error = %v, want a cancellation", err)
+	}
+	codes := make([]string, 0, len(canceled.CancellationReasons))
+	for _, reason := range canceled.CancellationReasons {
+		codes = append(codes, aws.ToString(reason.Code))
+	}
+	if want := []string{"None", "ConditionalCheckFailed"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("cancellation reasons = %v, want %v", codes, want)
+	}
+	if got := canceled.CancellationReasons[1].Item["status"]; !reflect.DeepEqual(got, s("PENDING")) {
+		t.Errorf("cancellation item status = %#v, want PENDING", got)
+	}
+	if items := client.Items("payments"); len(items) != 5 {
+		t.Errorf("canceled transaction wrote items, table has %d", len(items))
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	client := newPaymentsClient(t)
+	client.Latency = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("payments"),
+		Key:       map[string]types.AttributeValue{"merchantID": s("m1"), "paymentID": s("p1")},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetItem error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	client.Latency = 0
+	_, err = client.PutItem(canceled, &dynamodb.PutItemInput{
+		TableName: aws.String("payments"),
+		Item:      map[string]types.AttributeValue{"merchantID": s("m9"), "paymentID": s("p9")},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("PutItem error = %v, want %v", err, context.Canceled)
+	}
+	if items := client.Items("payments"); len(items) != 5 {
+		t.Errorf("canceled put wrote an item, table has %d", len(items))
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/diegocabrera89/ms-payment-core/logs"
 	"reflect"
+	"strings"
+	"time"
 	"unicode"
 )
 
@@ -38,9 +41,10 @@ func IsObjectNilEmpty(objet interface{}, ctx context.Context, request events.API
 }
 
 // SkipUpdatingFields skip the fields to update.
+// A field matches its exact name, its name with a lower case first letter, or any nested path below it.
 func SkipUpdatingFields(currentField string, skipFields []string) bool {
 	for _, item := range skipFields {
-		if item == ToLowerCase(currentField) {
+		if item == currentField || item == ToLowerCase(currentField) || strings.HasPrefix(currentField, item+".") {
 			return true
 		}
 	}
@@ -56,20 +60,158 @@ func ToLowerCase(input string) string {
 	return firstChar + input[1:]
 }
 
+// ValuesOption customizes how BuildUpdateValues and BuildPatchValues name struct fields.
+type ValuesOption func(*valuesOptions)
+
+// valuesOptions holds the settings of BuildUpdateValues and BuildPatchValues.
+type valuesOptions struct {
+	lowerCaseFieldNames bool
+}
+
+// WithLowerCaseFieldNames names untagged struct fields with a lower case first letter, as updates did before
+// dynamodbav tags were honored, instead of with the Go field name used by attributevalue.MarshalMap.
+func WithLowerCaseFieldNames() ValuesOption {
+	return func(o *valuesOptions) {
+		o.lowerCaseFieldNames = true
+	}
+}
+
+// newValuesOptions applies opts to the default settings.
+func newValuesOptions(opts []ValuesOption) valuesOptions {
+	options := valuesOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
 // BuildUpdateValues build field and values for update item from DynamoDB.
-func BuildUpdateValues(object interface{}, ctx context.Context, request events.APIGatewayProxyRequest) map[string]interface{} {
+// Fields are named like attributevalue.MarshalMap does: dynamodbav tags are honored, fields tagged "-"
+// and unexported fields are skipped, omitempty fields are skipped when zero and embedded structs are flattened.
+// object can be a struct, a pointer to a struct or a map.
+func BuildUpdateValues(object interface{}, ctx context.Context, request events.APIGatewayProxyRequest, opts ...ValuesOption) map[string]interface{} {
 	// Create map to store updated values.
 	updateValues := make(map[string]interface{})
 
-	// Obtain reflection of the Customer structure.
-	objectReflect := reflect.ValueOf(object)
-
-	// Iterate over object fields and add them to updateValues
-	for i := 0; i < objectReflect.NumField(); i++ {
-		field := objectReflect.Type().Field(i)
-		fieldValue := objectReflect.Field(i).Interface()
-		updateValues[field.Name] = fieldValue
+	objectReflect := indirectValue(reflect.ValueOf(object))
+	switch objectReflect.Kind() {
+	case reflect.Map:
+		iterator := objectReflect.MapRange()
+		for iterator.Next() {
+			updateValues[iterator.Key().String()] = iterator.Value().Interface()
+		}
+	case reflect.Struct:
+		collectStructValues(objectReflect, "", false, newValuesOptions(opts), updateValues)
 	}
 
 	return updateValues
 }
+
+// BuildPatchValues build field and values for a partial update from DynamoDB.
+// Nil pointers and zero values are left out so they do not overwrite stored attributes, and nested
+// structs are flattened into paths such as address.city so sibling attributes are preserved.
+// For maps, keys holding nil are returned as fields to remove and keys may be nested paths.
+// A struct can neither set a non-pointer field to its zero value nor remove an attribute: point to
+// the zero value to write it, and list attributes to remove separately.
+func BuildPatchValues(object interface{}, ctx context.Context, request events.APIGatewayProxyRequest, opts ...ValuesOption) (map[string]interface{}, []string) {
+	updateValues := make(map[string]interface{})
+	var removeFields []string
+
+	objectReflect := indirectValue(reflect.ValueOf(object))
+	switch objectReflect.Kind() {
+	case reflect.Map:
+		iterator := objectReflect.MapRange()
+		for iterator.Next() {
+			fieldName := iterator.Key().String()
+			fieldValue := iterator.Value()
+			if !fieldValue.IsValid() || (fieldValue.Kind() == reflect.Interface && fieldValue.IsNil()) {
+				removeFields = append(removeFields, fieldName)
+				continue
+			}
+			updateValues[fieldName] = fieldValue.Interface()
+		}
+	case reflect.Struct:
+		collectStructValues(objectReflect, "", true, newValuesOptions(opts), updateValues)
+	}
+
+	return updateValues, removeFields
+}
+
+// AttributeName returns the attribute name attributevalue.MarshalMap gives a struct field,
+// whether the field is tagged omitempty, and false when the field is not marshalled.
+func AttributeName(field reflect.StructField) (string, bool, bool) {
+	tag := field.Tag.Get("dynamodbav")
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return "", false, false
+	}
+	if len(name) == 0 {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range strings.Split(options, ",") {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, true
+}
+
+// collectStructValues adds the attributes of a struct to values, prefixing their names with prefix.
+// In patch mode zero values are skipped and nested structs are flattened into paths.
+func collectStructValues(structValue reflect.Value, prefix string, patch bool, options valuesOptions, values map[string]interface{}) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+		name, omitEmpty, marshalled := AttributeName(field)
+		if !marshalled {
+			continue
+		}
+
+		tag, _, _ := strings.Cut(field.Tag.Get("dynamodbav"), ",")
+		if field.Anonymous && len(tag) == 0 {
+			embedded := indirectValue(fieldValue)
+			if embedded.Kind() == reflect.Struct {
+				collectStructValues(embedded, prefix, patch, options, values)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if options.lowerCaseFieldNames && len(tag) == 0 {
+			name = ToLowerCase(name)
+		}
+		if fieldValue.IsZero() && (patch || omitEmpty) {
+			continue
+		}
+
+		nested := indirectValue(fieldValue)
+		if patch && isNestedStruct(nested) {
+			collectStructValues(nested, prefix+name+".", patch, options, values)
+			continue
+		}
+		values[prefix+name] = fieldValue.Interface()
+	}
+}
+
+// isNestedStruct reports whether a value is a struct marshalled as a map of attributes.
+func isNestedStruct(value reflect.Value) bool {
+	if value.Kind() != reflect.Struct || value.Type() == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	marshalerType := reflect.TypeOf((*attributevalue.Marshaler)(nil)).Elem()
+	return !value.Type().Implements(marshalerType) && !reflect.PointerTo(value.Type()).Implements(marshalerType)
+}
+
+// indirectValue dereferences pointers and interfaces, returning an invalid value for nil.
+func indirectValue(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"context"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
@@ -42,14 +43,54 @@ func GetPrimaryKey(namePrimaryKey, valuePrimaryKey string) map[string]types.Attr
 	}
 }
 
+// UpdateOperation defines how a field is written by an update expression.
+type UpdateOperation string
+
+const (
+	// UpdateOperationSet replaces the attribute with the new value.
+	UpdateOperationSet UpdateOperation = "SET"
+	// UpdateOperationAdd atomically adds the value to a number or the members to a set.
+	UpdateOperationAdd UpdateOperation = "ADD"
+	// UpdateOperationAppend appends the value, which must be a list, to a list attribute.
+	UpdateOperationAppend UpdateOperation = "APPEND"
+	// UpdateOperationSetIfNotExists sets the attribute only when it does not exist yet.
+	UpdateOperationSetIfNotExists UpdateOperation = "IF_NOT_EXISTS"
+)
+
 // BuildUpdateExpression build expression for update item from DynamoDB.
 func BuildUpdateExpression(updateValues map[string]interface{}, skipFields []string, ctx context.Context, request events.APIGatewayProxyRequest) (expression.UpdateBuilder, error) {
-	logs.LogTrackingInfo("UpdateCustomerRepository BuildUpdateExpression", ctx, request)
+	return BuildPatchExpression(updateValues, nil, skipFields, nil, ctx, request)
+}
+
+// BuildPatchExpression build expression for update item from DynamoDB, writing each field with its
+// operation (SET by default) and removing removeFields. Field names are attribute names or nested
+// paths such as address.city.
+func BuildPatchExpression(updateValues map[string]interface{}, removeFields []string, skipFields []string, operations map[string]UpdateOperation, ctx context.Context, request events.APIGatewayProxyRequest) (expression.UpdateBuilder, error) {
+	logs.LogTrackingInfo("BuildPatchExpression", ctx, request)
 	updateBuilder := expression.UpdateBuilder{}
 
 	for fieldName, value := range updateValues {
+		if SkipUpdatingFields(fieldName, skipFields) {
+			continue
+		}
+		name := expression.Name(fieldName)
+		switch operations[fieldName] {
+		case UpdateOperationAdd:
+			updateBuilder = updateBuilder.Add(name, expression.Value(value))
+		case UpdateOperationAppend:
+			updateBuilder = updateBuilder.Set(name, expression.ListAppend(expression.IfNotExists(name, expression.Value([]interface{}{})), expression.Value(value)))
+		case UpdateOperationSetIfNotExists:
+			updateBuilder = updateBuilder.Set(name, expression.IfNotExists(name, expression.Value(value)))
+		case UpdateOperationSet, "":
+			updateBuilder = updateBuilder.Set(name, expression.Value(value))
+		default:
+			return updateBuilder, fmt.Errorf("unsupported update operation %s for %s", operations[fieldName], fieldName)
+		}
+	}
+
+	for _, fieldName := range removeFields {
 		if !SkipUpdatingFields(fieldName, skipFields) {
-			updateBuilder = updateBuilder.Set(expression.Name(ToLowerCase(fieldName)), expression.Value(value))
+			updateBuilder = updateBuilder.Remove(expression.Name(fieldName))
 		}
 	}
 	return updateBuilder, nil
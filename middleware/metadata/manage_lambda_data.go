@@ -50,6 +50,6 @@ func MiddlewareMetadata(HandlerMiddleware func(ctx context.Context, request even
 	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 		InputData(ctx, request)
 		// Call to actual handling function.
-		return HandlerMiddleware(ctx, request)
+		return HandlerMiddleware(tracking.WithRequest(ctx, request), request)
 	}
 }
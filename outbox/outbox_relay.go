@@ -0,0 +1,95 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"github.com/diegocabrera89/ms-payment-core/streams"
+	"sync"
+)
+
+// Publisher sends outbox messages to an event bus.
+// Messages are delivered at least once, so consumers must deduplicate them by ID.
+type Publisher interface {
+	Publish(ctx context.Context, message Message) error
+}
+
+// MemoryPublisher keeps the published messages in memory, for tests.
+type MemoryPublisher struct {
+	mutex    sync.Mutex
+	messages []Message
+	// Err, when set, is returned by Publish instead of recording the message.
+	Err error
+}
+
+// NewMemoryPublisher creates an empty MemoryPublisher.
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish records the message.
+func (p *MemoryPublisher) Publish(_ context.Context, message Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// Messages returns the published messages in publication order.
+func (p *MemoryPublisher) Messages() []Message {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Message{}, p.messages...)
+}
+
+// Relay publishes the messages inserted in the outbox table, driven by the stream of that table,
+// which must include new images.
+type Relay struct {
+	outbox     *Outbox
+	publisher  Publisher
+	dispatcher *streams.Dispatcher
+}
+
+// NewRelay creates a Relay publishing the messages of outbox through publisher.
+func NewRelay(outbox *Outbox, publisher Publisher) *Relay {
+	relay := &Relay{
+		outbox:     outbox,
+		publisher:  publisher,
+		dispatcher: streams.NewDispatcher(),
+	}
+	relay.Register(relay.dispatcher)
+	return relay
+}
+
+// Register adds the relay handler to a dispatcher shared with other handlers of the outbox stream.
+func (r *Relay) Register(dispatcher *streams.Dispatcher) {
+	streams.Handle(dispatcher, r.relay, streams.OnEvents(events.DynamoDBOperationTypeInsert))
+}
+
+// HandleEvent publishes the messages of a stream event of the outbox table, to be used as the Lambda handler.
+func (r *Relay) HandleEvent(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	return r.dispatcher.HandleEvent(ctx, event)
+}
+
+// relay publishes a pending message and marks it as delivered.
+func (r *Relay) relay(ctx context.Context, change streams.Change[Message]) error {
+	request := events.APIGatewayProxyRequest{}
+	if change.New == nil || change.New.Status != StatusPending {
+		return nil
+	}
+	message := *change.New
+	logs.LogTrackingInfoData("Relay message", message.ID, ctx, request)
+	if err := r.publisher.Publish(ctx, message); err != nil {
+		logs.LogTrackingError("Relay", "Publish", ctx, request, err)
+		return fmt.Errorf("publish message %s: %w", message.ID, err)
+	}
+	if err := r.outbox.MarkDelivered(ctx, request, message.ID); err != nil {
+		logs.LogTrackingError("Relay", "MarkDelivered", ctx, request, err)
+		return fmt.Errorf("mark message %s as delivered: %w", message.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+	"time"
+)
+
+const (
+	// KeyAttribute is the partition key of the outbox table.
+	KeyAttribute = "outboxID"
+	// StatusPending marks a message not yet published.
+	StatusPending = "PENDING"
+	// StatusDelivered marks a message published by the relay.
+	StatusDelivered = "DELIVERED"
+)
+
+// Message is an event stored in the outbox table until the relay publishes it.
+type Message struct {
+	ID          string `dynamodbav:"outboxID"`
+	EventType   string `dynamodbav:"eventType"`
+	AggregateID string `dynamodbav:"aggregateID"`
+	Payload     string `dynamodbav:"payload"`
+	Status      string `dynamodbav:"status"`
+	CreatedAt   string `dynamodbav:"createdAt"`
+	DeliveredAt string `dynamodbav:"deliveredAt,omitempty"`
+}
+
+// DecodePayload unmarshals the JSON payload of the message into out.
+func (m Message) DecodePayload(out interface{}) error {
+	return json.Unmarshal([]byte(m.Payload), out)
+}
+
+// Outbox writes messages to the outbox table in the same transaction as the domain items.
+// The outbox table has the string partition key outboxID and a stream feeding the Relay.
+type Outbox struct {
+	repository *dynamodbcore.DynamoDBRepository
+}
+
+// NewOutbox creates an Outbox on the table of repository.
+func NewOutbox(repository *dynamodbcore.DynamoDBRepository) *Outbox {
+	return &Outbox{repository: repository}
+}
+
+// NewMessage creates a pending message with a random ID and payload marshalled as JSON.
+func NewMessage(eventType string, aggregateID string, payload interface{}) (Message, error) {
+	encodedPayload, err := json.Marshal(payload)
+	if err != nil {
+		return Message{}, fmt.Errorf("marshal payload: %w", err)
+	}
+	id, err := newMessageID()
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		ID:          id,
+		EventType:   eventType,
+		AggregateID: aggregateID,
+		Payload:     string(encodedPayload),
+		Status:      StatusPending,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+// Add adds the messages to transaction, so they are stored only if every other operation succeeds.
+func (o *Outbox) Add(transaction *dynamodbcore.TransactionBuilder, messages ...Message) error {
+	for _, message := range messages {
+		item, err := attributevalue.MarshalMap(message)
+		if err != nil {
+			return fmt.Errorf("marshal message %s: %w", message.ID, err)
+		}
+		transaction.Put(o.repository.TableName(), item, expression.AttributeNotExists(expression.Name(KeyAttribute)))
+	}
+	return nil
+}
+
+// PutWithMessages stores item in the table of repository and the messages in the outbox in one transaction.
+// The item is put like PutItemCore does with opts, so a create or version conflict cancels the whole transaction
+// and fails with ErrAlreadyExists or ErrVersionConflict.
+func (o *Outbox) PutWithMessages(ctx context.Context, request events.APIGatewayProxyRequest, repository dynamodbcore.CoreRepository, item map[string]types.AttributeValue, messages []Message, opts ...dynamodbcore.PutOption) error {
+	logs.LogTrackingInfo("PutWithMessages", ctx, request)
+	transaction := dynamodbcore.NewTransaction().PutItem(ctx, request, repository, item, opts...)
+	if err := o.Add(transaction, messages...); err != nil {
+		logs.LogTrackingError("PutWithMessages", "Add", ctx, request, err)
+		return err
+	}
+	return repository.TransactWriteCore(ctx, request, transaction)
+}
+
+// MarkDelivered records that a message was published.
+func (o *Outbox) MarkDelivered(ctx context.Context, request events.APIGatewayProxyRequest, messageID string) error {
+	delivered := map[string]interface{}{
+		"status":      StatusDelivered,
+		"deliveredAt": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	return o.repository.UpdateItemCore(ctx, request, delivered, dynamodbcore.NewStringKey(KeyAttribute, messageID), nil, dynamodbcore.WithPatch())
+}
+
+// newMessageID returns a random version 4 UUID.
+func newMessageID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("generate message ID: %w", err)
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
@@ -0,0 +1,73 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbcore"
+	"github.com/diegocabrera89/ms-payment-core/dynamodbtest"
+	"github.com/diegocabrera89/ms-payment-core/outbox"
+	"testing"
+)
+
+func newRepository(t *testing.T, client *dynamodbtest.Client, table string, partitionKeyName string) *dynamodbcore.DynamoDBRepository {
+	t.Helper()
+	repository, err := dynamodbcore.NewDynamoDBRepository(table, "us-east-1",
+		dynamodbcore.WithClient(client),
+		dynamodbcore.WithCursorSecret([]byte("test-secret")),
+		dynamodbcore.WithKeySchema(partitionKeyName, ""),
+	)
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository error = %v", err)
+	}
+	return repository
+}
+
+func TestPutWithMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		stored       bool
+		cached       bool
+		opts         []dynamodbcore.PutOption
+		wantErr      error
+		wantMessages int
+	}{
+		{name: "create new item", opts: []dynamodbcore.PutOption{dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)}, wantMessages: 1},
+		{name: "create existing item", stored: true, opts: []dynamodbcore.PutOption{dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)}, wantErr: dynamodbcore.ErrAlreadyExists},
+		{name: "upsert existing item", stored: true, wantMessages: 1},
+		{name: "create existing item through the cache", stored: true, cached: true, opts: []dynamodbcore.PutOption{dynamodbcore.WithPutMode(dynamodbcore.PutModeCreate)}, wantErr: dynamodbcore.ErrAlreadyExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := dynamodbtest.NewClient(
+				dynamodbtest.TableDefinition{Name: "payments", PartitionKey: "id"},
+				dynamodbtest.TableDefinition{Name: "outbox", PartitionKey: outbox.KeyAttribute},
+			)
+			payments := newRepository(t, client, "payments", "id")
+			outboxRepository := newRepository(t, client, "outbox", outbox.KeyAttribute)
+			ctx := context.Background()
+			if test.stored {
+				if err := payments.PutItemCore(ctx, events.APIGatewayProxyRequest{}, map[string]types.AttributeValue{"id": dynamodbcore.StringValue("p1")}); err != nil {
+					t.Fatalf("PutItemCore error = %v", err)
+				}
+			}
+			message, err := outbox.NewMessage("PaymentCreated", "p1", map[string]string{"id": "p1"})
+			if err != nil {
+				t.Fatalf("NewMessage error = %v", err)
+			}
+			item := map[string]types.AttributeValue{"id": dynamodbcore.StringValue("p1"), "status": dynamodbcore.StringValue("PENDING")}
+			var repository dynamodbcore.CoreRepository = payments
+			if test.cached {
+				repository = dynamodbcore.NewCachedRepository(payments)
+			}
+			err = outbox.NewOutbox(outboxRepository).PutWithMessages(ctx, events.APIGatewayProxyRequest{}, repository, item, []outbox.Message{message}, test.opts...)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("PutWithMessages error = %v, want %v", err, test.wantErr)
+			}
+			if messages := client.Items("outbox"); len(messages) != test.wantMessages {
+				t.Errorf("outbox has %d messages, want %d", len(messages), test.wantMessages)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package streams
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"math/big"
+	"sort"
+)
+
+// FromStreamImage converts an image of a stream record into the attribute values used by the SDK.
+func FromStreamImage(image map[string]events.DynamoDBAttributeValue) (map[string]types.AttributeValue, error) {
+	if image == nil {
+		return nil, nil
+	}
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		attribute, err := FromStreamAttribute(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		item[name] = attribute
+	}
+	return item, nil
+}
+
+// FromStreamAttribute converts an attribute value of a stream record into the attribute value used by the SDK.
+func FromStreamAttribute(value events.DynamoDBAttributeValue) (types.AttributeValue, error) {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}, nil
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}, nil
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}, nil
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}, nil
+	case events.DataTypeNull:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}, nil
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}, nil
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}, nil
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(value.List()))
+		for i, element := range value.List() {
+			attribute, err := FromStreamAttribute(element)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			list = append(list, attribute)
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case events.DataTypeMap:
+		attributes, err := FromStreamImage(value.Map())
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberM{Value: attributes}, nil
+	}
+	return nil, fmt.Errorf("unsupported stream attribute type %d", value.DataType())
+}
+
+// ChangedAttributes returns the sorted names of the top-level attributes that differ between two images,
+// including the attributes added or removed.
+func ChangedAttributes(oldImage map[string]types.AttributeValue, newImage map[string]types.AttributeValue) []string {
+	var changed []string
+	for name, oldValue := range oldImage {
+		if newValue, found := newImage[name]; !found || !EqualAttributeValues(oldValue, newValue) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range newImage {
+		if _, found := oldImage[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// EqualAttributeValues reports whether two attribute values are equal, ignoring the order of set elements.
+// Numbers are compared by value, so 1 and 1.0 are equal.
+func EqualAttributeValues(left types.AttributeValue, right types.AttributeValue) bool {
+	switch l := left.(type) {
+	case *types.AttributeValueMemberS:
+		r, ok := right.(*types.AttributeValueMemberS)
+		return ok && l.Value == r.Value
+	case *types.AttributeValueMemberN:
+		r, ok := right.(*types.AttributeValueMemberN)
+		return ok && canonicalNumber(l.Value) == canonicalNumber(r.Value)
+	case *types.AttributeValueMemberB:
+		r, ok := right.(*types.AttributeValueMemberB)
+		return ok && bytes.Equal(l.Value, r.Value)
+	case *types.AttributeValueMemberBOOL:
+		r, ok := right.(*types.AttributeValueMemberBOOL)
+		return ok && l.Value == r.Value
+	case *types.AttributeValueMemberNULL:
+		_, ok := right.(*types.AttributeValueMemberNULL)
+		return ok
+	case *types.AttributeValueMemberSS:
+		r, ok := right.(*types.AttributeValueMemberSS)
+		return ok && equalSets(l.Value, r.Value)
+	case *types.AttributeValueMemberNS:
+		r, ok := right.(*types.AttributeValueMemberNS)
+		if !ok {
+			return false
+		}
+		leftSet := make([]string, 0, len(l.Value))
+		for _, element := range l.Value {
+			leftSet = append(leftSet, canonicalNumber(element))
+		}
+		rightSet := make([]string, 0, len(r.Value))
+		for _, element := range r.Value {
+			rightSet = append(rightSet, canonicalNumber(element))
+		}
+		return equalSets(leftSet, rightSet)
+	case *types.AttributeValueMemberBS:
+		r, ok := right.(*types.AttributeValueMemberBS)
+		if !ok {
+			return false
+		}
+		leftSet := make([]string, 0, len(l.Value))
+		for _, element := range l.Value {
+			leftSet = append(leftSet, string(element))
+		}
+		rightSet := make([]string, 0, len(r.Value))
+		for _, element := range r.Value {
+			rightSet = append(rightSet, string(element))
+		}
+		return equalSets(leftSet, rightSet)
+	case *types.AttributeValueMemberL:
+		r, ok := right.(*types.AttributeValueMemberL)
+		if !ok || len(l.Value) != len(r.Value) {
+			return false
+		}
+		for i := range l.Value {
+			if !EqualAttributeValues(l.Value[i], r.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		r, ok := right.(*types.AttributeValueMemberM)
+		return ok && len(ChangedAttributes(l.Value, r.Value)) == 0
+	}
+	return false
+}
+
+// canonicalNumber returns the exact value of a number as a fraction in lowest terms, so equal numbers
+// written differently give the same string. Values that are not numbers are returned as is.
+func canonicalNumber(number string) string {
+	value, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return number
+	}
+	return value.RatString()
+}
+
+// equalSets reports whether two sets hold the same elements.
+func equalSets(left []string, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	counts := make(map[string]int, len(left))
+	for _, element := range left {
+		counts[element]++
+	}
+	for _, element := range right {
+		counts[element]--
+		if counts[element] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package streams_test
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/streams"
+	"reflect"
+	"testing"
+)
+
+func TestFromStreamAttribute(t *testing.T) {
+	tests := []struct {
+		name  string
+		value events.DynamoDBAttributeValue
+		want  types.AttributeValue
+	}{
+		{name: "string", value: events.NewStringAttribute("PAID"), want: &types.AttributeValueMemberS{Value: "PAID"}},
+		{name: "number", value: events.NewNumberAttribute("10.5"), want: &types.AttributeValueMemberN{Value: "10.5"}},
+		{name: "binary", value: events.NewBinaryAttribute([]byte{1, 2}), want: &types.AttributeValueMemberB{Value: []byte{1, 2}}},
+		{name: "boolean", value: events.NewBooleanAttribute(true), want: &types.AttributeValueMemberBOOL{Value: true}},
+		{name: "null", value: events.NewNullAttribute(), want: &types.AttributeValueMemberNULL{Value: true}},
+		{name: "string set", value: events.NewStringSetAttribute([]string{"card", "cash"}), want: &types.AttributeValueMemberSS{Value: []string{"card", "cash"}}},
+		{name: "number set", value: events.NewNumberSetAttribute([]string{"1", "2"}), want: &types.AttributeValueMemberNS{Value: []string{"1", "2"}}},
+		{name: "binary set", value: events.NewBinarySetAttribute([][]byte{{1}, {2}}), want: &types.AttributeValueMemberBS{Value: [][]byte{{1}, {2}}}},
+		{
+			name:  "list",
+			value: events.NewListAttribute([]events.DynamoDBAttributeValue{events.NewStringAttribute("a"), events.NewNumberAttribute("1")}),
+			want:  &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "a"}, &types.AttributeValueMemberN{Value: "1"}}},
+		},
+		{
+			name: "map",
+			value: events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+				"address": events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{"city": events.NewStringAttribute("Quito")}),
+			}),
+			want: &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": &types.AttributeValueMemberS{Value: "Quito"}}},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := streams.FromStreamAttribute(test.value)
+			if err != nil {
+				t.Fatalf("FromStreamAttribute error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("FromStreamAttribute = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChangedAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldImage map[string]types.AttributeValue
+		newImage map[string]types.AttributeValue
+		want     []string
+	}{
+		{
+			name:     "insert",
+			newImage: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "p1"}, "status": &types.AttributeValueMemberS{Value: "PENDING"}},
+			want:     []string{"id", "status"},
+		},
+		{
+			name:     "remove",
+			oldImage: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "p1"}},
+			want:     []string{"id"},
+		},
+		{
+			name:     "modified, added and removed attributes",
+			oldImage: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "p1"}, "status": &types.AttributeValueMemberS{Value: "PENDING"}, "note": &types.AttributeValueMemberS{Value: "x"}},
+			newImage: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "p1"}, "status": &types.AttributeValueMemberS{Value: "PAID"}, "paidAt": &types.AttributeValueMemberS{Value: "now"}},
+			want:     []string{"note", "paidAt", "status"},
+		},
+		{
+			name:     "equal numbers written differently",
+			oldImage: map[string]types.AttributeValue{"amount": &types.AttributeValueMemberN{Value: "1"}, "fees": &types.AttributeValueMemberNS{Value: []string{"1", "2.50"}}},
+			newImage: map[string]types.AttributeValue{"amount": &types.AttributeValueMemberN{Value: "1.0"}, "fees": &types.AttributeValueMemberNS{Value: []string{"2.5", "1E0"}}},
+		},
+		{
+			name:     "different numbers",
+			oldImage: map[string]types.AttributeValue{"amount": &types.AttributeValueMemberN{Value: "1"}, "fees": &types.AttributeValueMemberNS{Value: []string{"1", "2"}}},
+			newImage: map[string]types.AttributeValue{"amount": &types.AttributeValueMemberN{Value: "1.01"}, "fees": &types.AttributeValueMemberNS{Value: []string{"1", "3"}}},
+			want:     []string{"amount", "fees"},
+		},
+		{
+			name:     "reordered sets",
+			oldImage: map[string]types.AttributeValue{"tags": &types.AttributeValueMemberSS{Value: []string{"card", "cash"}}},
+			newImage: map[string]types.AttributeValue{"tags": &types.AttributeValueMemberSS{Value: []string{"cash", "card"}}},
+		},
+		{
+			name:     "nested change",
+			oldImage: map[string]types.AttributeValue{"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": &types.AttributeValueMemberS{Value: "Quito"}}}},
+			newImage: map[string]types.AttributeValue{"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": &types.AttributeValueMemberS{Value: "Cuenca"}}}},
+			want:     []string{"address"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := streams.ChangedAttributes(test.oldImage, test.newImage); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ChangedAttributes = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/diegocabrera89/ms-payment-core/logs"
+)
+
+// Record is a stream record with its keys and images converted into SDK attribute values.
+type Record struct {
+	EventID           string
+	EventName         events.DynamoDBOperationType
+	SequenceNumber    string
+	Keys              map[string]types.AttributeValue
+	OldImage          map[string]types.AttributeValue
+	NewImage          map[string]types.AttributeValue
+	ChangedAttributes []string
+	Raw               events.DynamoDBEventRecord
+}
+
+// Changed reports whether any of the given attributes changed.
+func (r Record) Changed(names ...string) bool {
+	for _, changed := range r.ChangedAttributes {
+		for _, name := range names {
+			if changed == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Change is a stream record with its images unmarshalled into T.
+// Old is nil for INSERT records and New is nil for REMOVE records, or when the stream view type omits them.
+type Change[T any] struct {
+	Record
+	Old *T
+	New *T
+}
+
+// Dispatcher routes the records of a DynamoDB stream event to the handlers registered with Handle.
+type Dispatcher struct {
+	routes []route
+}
+
+// route is a handler and the conditions a record must meet to reach it.
+type route struct {
+	eventNames []events.DynamoDBOperationType
+	changed    []string
+	predicate  func(record Record) bool
+	handle     func(ctx context.Context, record Record) error
+}
+
+// RouteOption restricts the records a handler receives.
+type RouteOption func(*route)
+
+// OnEvents only routes records of the given event names, every event name by default.
+func OnEvents(eventNames ...events.DynamoDBOperationType) RouteOption {
+	return func(r *route) {
+		r.eventNames = append(r.eventNames, eventNames...)
+	}
+}
+
+// OnChange only routes records where at least one of the given attributes changed.
+// INSERT and REMOVE records change every attribute of their image.
+func OnChange(names ...string) RouteOption {
+	return func(r *route) {
+		r.changed = append(r.changed, names...)
+	}
+}
+
+// When only routes records for which predicate returns true,
+// for example to tell entity types apart in a single-table design.
+func When(predicate func(record Record) bool) RouteOption {
+	return func(r *route) {
+		r.predicate = predicate
+	}
+}
+
+// NewDispatcher creates a Dispatcher without handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Handle registers handler for the records matching opts, with their images unmarshalled into T.
+// A record matching several handlers is given to each of them in registration order.
+func Handle[T any](dispatcher *Dispatcher, handler func(ctx context.Context, change Change[T]) error, opts ...RouteOption) {
+	newRoute := route{
+		handle: func(ctx context.Context, record Record) error {
+			change := Change[T]{Record: record}
+			if record.OldImage != nil {
+				change.Old = new(T)
+				if err := attributevalue.UnmarshalMap(record.OldImage, change.Old); err != nil {
+					return fmt.Errorf("unmarshal old image: %w", err)
+				}
+			}
+			if record.NewImage != nil {
+				change.New = new(T)
+				if err := attributevalue.UnmarshalMap(record.NewImage, change.New); err != nil {
+					return fmt.Errorf("unmarshal new image: %w", err)
+				}
+			}
+			return handler(ctx, change)
+		},
+	}
+	for _, opt := range opts {
+		opt(&newRoute)
+	}
+	dispatcher.routes = append(dispatcher.routes, newRoute)
+}
+
+// HandleEvent processes the records of a stream event in order, to be used as the Lambda handler.
+// Processing stops at the first failing record, which is reported as a batch item failure so that
+// Lambda retries from it when ReportBatchItemFailures is enabled on the event source mapping.
+func (d *Dispatcher) HandleEvent(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	request := events.APIGatewayProxyRequest{}
+	logs.LogTrackingInfoData("HandleEvent records", len(event.Records), ctx, request)
+	response := events.DynamoDBEventResponse{BatchItemFailures: []events.DynamoDBBatchItemFailure{}}
+	for _, eventRecord := range event.Records {
+		if err := d.handleRecord(ctx, eventRecord); err != nil {
+			logs.LogTrackingError("HandleEvent", "handleRecord", ctx, request, fmt.Errorf("event %s: %w", eventRecord.EventID, err))
+			response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: eventRecord.Change.SequenceNumber,
+			})
+			return response, nil
+		}
+	}
+	return response, nil
+}
+
+// handleRecord converts a record and gives it to every matching handler.
+func (d *Dispatcher) handleRecord(ctx context.Context, eventRecord events.DynamoDBEventRecord) error {
+	record, err := NewRecord(eventRecord)
+	if err != nil {
+		return err
+	}
+	for _, candidate := range d.routes {
+		if !candidate.matches(record) {
+			continue
+		}
+		if errorHandle := candidate.handle(ctx, record); errorHandle != nil {
+			return errorHandle
+		}
+	}
+	return nil
+}
+
+// matches reports whether a record meets the conditions of the route.
+func (r route) matches(record Record) bool {
+	if len(r.eventNames) != 0 {
+		found := false
+		for _, eventName := range r.eventNames {
+			found = found || eventName == record.EventName
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.changed) != 0 && !record.Changed(r.changed...) {
+		return false
+	}
+	return r.predicate == nil || r.predicate(record)
+}
+
+// NewRecord converts a stream record, computing the attributes that changed between its images.
+func NewRecord(eventRecord events.DynamoDBEventRecord) (Record, error) {
+	keys, err := FromStreamImage(eventRecord.Change.Keys)
+	if err != nil {
+		return Record{}, fmt.Errorf("convert keys: %w", err)
+	}
+	oldImage, err := FromStreamImage(eventRecord.Change.OldImage)
+	if err != nil {
+		return Record{}, fmt.Errorf("convert old image: %w", err)
+	}
+	newImage, err := FromStreamImage(eventRecord.Change.NewImage)
+	if err != nil {
+		return Record{}, fmt.Errorf("convert new image: %w", err)
+	}
+	return Record{
+		EventID:           eventRecord.EventID,
+		EventName:         events.DynamoDBOperationType(eventRecord.EventName),
+		SequenceNumber:    eventRecord.Change.SequenceNumber,
+		Keys:              keys,
+		OldImage:          oldImage,
+		NewImage:          newImage,
+		ChangedAttributes: ChangedAttributes(oldImage, newImage),
+		Raw:               eventRecord,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package streams_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/diegocabrera89/ms-payment-core/streams"
+	"reflect"
+	"testing"
+)
+
+type payment struct {
+	ID     string `dynamodbav:"id"`
+	Status string `dynamodbav:"status"`
+}
+
+func streamRecord(sequenceNumber string, eventName events.DynamoDBOperationType, id string, oldStatus string, newStatus string) events.DynamoDBEventRecord {
+	image := func(status string) map[string]events.DynamoDBAttributeValue {
+		if len(status) == 0 {
+			return nil
+		}
+		return map[string]events.DynamoDBAttributeValue{"id": events.NewStringAttribute(id), "status": events.NewStringAttribute(status)}
+	}
+	return events.DynamoDBEventRecord{
+		EventID:   "event-" + sequenceNumber,
+		EventName: string(eventName),
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: sequenceNumber,
+			Keys:           map[string]events.DynamoDBAttributeValue{"id": events.NewStringAttribute(id)},
+			OldImage:       image(oldStatus),
+			NewImage:       image(newStatus),
+		},
+	}
+}
+
+func TestDispatcherHandleEvent(t *testing.T) {
+	tests := []struct {
+		name         string
+		failOn       string
+		wantHandled  []string
+		wantFailures []string
+	}{
+		{name: "every record handled", wantHandled: []string{"p1", "p2", "p3"}},
+		{name: "failing record stops the batch", failOn: "p2", wantHandled: []string{"p1", "p2"}, wantFailures: []string{"2"}},
+		{name: "failing first record", failOn: "p1", wantHandled: []string{"p1"}, wantFailures: []string{"1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handled []string
+			dispatcher := streams.NewDispatcher()
+			streams.Handle(dispatcher, func(ctx context.Context, change streams.Change[payment]) error {
+				handled = append(handled, change.New.ID)
+				if change.New.ID == test.failOn {
+					return errors.New("handler failed")
+				}
+				return nil
+			}, streams.OnChange("status"))
+			event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+				streamRecord("1", events.DynamoDBOperationTypeInsert, "p1", "", "PENDING"),
+				streamRecord("2", events.DynamoDBOperationTypeModify, "p2", "PENDING", "PAID"),
+				// Unchanged status: not routed to the handler.
+				streamRecord("3", events.DynamoDBOperationTypeModify, "p4", "PAID", "PAID"),
+				streamRecord("4", events.DynamoDBOperationTypeModify, "p3", "PENDING", "PAID"),
+			}}
+
+			response, err := dispatcher.HandleEvent(context.Background(), event)
+			if err != nil {
+				t.Fatalf("HandleEvent error = %v", err)
+			}
+			if !reflect.DeepEqual(handled, test.wantHandled) {
+				t.Errorf("handled = %v, want %v", handled, test.wantHandled)
+			}
+			var failures []string
+			for _, failure := range response.BatchItemFailures {
+				failures = append(failures, failure.ItemIdentifier)
+			}
+			if !reflect.DeepEqual(failures, test.wantFailures) {
+				t.Errorf("batch item failures = %v, want %v", failures, test.wantFailures)
+			}
+		})
+	}
+}
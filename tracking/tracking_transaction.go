@@ -3,9 +3,31 @@ package tracking
 import (
 	"context"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
+// requestContextKey is the context key under which the API Gateway request is stored.
+type requestContextKey struct{}
+
 // GetRequestId get request id reference.
+// Outside API Gateway invocations, such as stream consumers, it falls back to the Lambda request id.
 func GetRequestId(ctx context.Context, request events.APIGatewayProxyRequest) string {
-	return request.RequestContext.RequestID
+	if len(request.RequestContext.RequestID) != 0 {
+		return request.RequestContext.RequestID
+	}
+	if lambdaContext, found := lambdacontext.FromContext(ctx); found {
+		return lambdaContext.AwsRequestID
+	}
+	return ""
+}
+
+// WithRequest store the API Gateway request in the context.
+func WithRequest(ctx context.Context, request events.APIGatewayProxyRequest) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, request)
+}
+
+// RequestFromContext get the API Gateway request stored in the context, empty when there is none.
+func RequestFromContext(ctx context.Context) events.APIGatewayProxyRequest {
+	request, _ := ctx.Value(requestContextKey{}).(events.APIGatewayProxyRequest)
+	return request
 }